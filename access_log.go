@@ -0,0 +1,228 @@
+package xlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+const (
+	AccessFormatJson     = "json"     //message为access 请求信息作为字段
+	AccessFormatCommon   = "common"   //Apache common格式作为message
+	AccessFormatCombined = "combined" //Apache combined格式作为message
+
+	accessTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// AccessLogOptions 访问日志配置 为空时使用json格式
+type AccessLogOptions struct {
+	Format     string               //json common combined 默认json
+	SkipPaths  []string             //不记录的路径 如/health 以*结尾时按前缀匹配
+	TrustProxy bool                 //是否信任X-Forwarded-For和X-Real-IP 只在反向代理后面时开启
+	Level      func(status int) int //按状态码选择等级 默认5xx为error 4xx为warn 其他为info
+	Message    string               //json格式的message 默认access
+}
+
+// NewAccessLogHandler 记录每个请求的方法 路径 状态码 字节数 耗时 客户端ip和user agent
+// next中的panic会被恢复 以error等级打印堆栈 还没有写入状态码时返回500 http.ErrAbortHandler仍继续向上panic
+// 访问日志记录实际返回的状态码 panicked字段为true 等级至少为error
+// 打印使用请求的context 请求带有trace时会自动带上trace id
+func NewAccessLogHandler(w Writer, next http.Handler, opts *AccessLogOptions) http.Handler {
+	if opts == nil {
+		opts = new(AccessLogOptions)
+	}
+	o := *opts
+	o.Format = strings.ToLower(o.Format)
+	if o.Level == nil {
+		o.Level = accessLevel
+	}
+	if o.Message == "" {
+		o.Message = "access"
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if o.skip(r.URL.Path) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: rw}
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				w.ErrorCtxW(r.Context(), "http handler panic", Field("panic", fmt.Sprint(rec)),
+					Field("method", r.Method), Field("path", r.URL.Path), Field("stack", string(debug.Stack())))
+				sw.panicked = true
+				if !sw.wroteHeader {
+					http.Error(sw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}
+			o.log(w, r, sw, start)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+func accessLevel(status int) int {
+	switch {
+	case status >= 500:
+		return ErrorLevel
+	case status >= 400:
+		return WarnLevel
+	default:
+		return InfoLevel
+	}
+}
+
+func (o *AccessLogOptions) skip(path string) bool {
+	for _, p := range o.SkipPaths {
+		if strings.HasSuffix(p, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(p, "*")) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *AccessLogOptions) log(w Writer, r *http.Request, sw *statusWriter, start time.Time) {
+	ctx, status := r.Context(), sw.statusCode()
+	level := o.Level(status)
+	if sw.panicked && level < ErrorLevel { //已写入状态码后panic 状态码可能不是5xx
+		level = ErrorLevel
+	}
+	if o.Format == AccessFormatCommon || o.Format == AccessFormatCombined {
+		line := o.apacheLine(r, status, sw.bytes, start)
+		switch level {
+		case DebugLevel:
+			w.DebugCtx(ctx, line)
+		case WarnLevel:
+			w.WarnCtx(ctx, line)
+		case InfoLevel:
+			w.InfoCtx(ctx, line)
+		default:
+			w.ErrorCtx(ctx, line)
+		}
+		return
+	}
+	fields := []LogField{
+		Field("method", r.Method),
+		Field("path", r.URL.Path),
+		Field("query", r.URL.RawQuery),
+		Field("proto", r.Proto),
+		Field("status", status),
+		Field("bytes", sw.bytes),
+		Field("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		Field("remote_ip", o.remoteIp(r)),
+		Field("user_agent", r.UserAgent()),
+		Field("referer", r.Referer()),
+	}
+	if sw.panicked {
+		fields = append(fields, Field("panicked", true))
+	}
+	switch level {
+	case DebugLevel:
+		w.DebugCtxW(ctx, o.Message, fields...)
+	case WarnLevel:
+		w.WarnCtxW(ctx, o.Message, fields...)
+	case InfoLevel:
+		w.InfoCtxW(ctx, o.Message, fields...)
+	default:
+		w.ErrorCtxW(ctx, o.Message, fields...)
+	}
+}
+
+// apacheLine common: host ident authuser [date] "request" status bytes combined再加上"referer" "user-agent"
+func (o *AccessLogOptions) apacheLine(r *http.Request, status int, bytes int64, start time.Time) string {
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprint(bytes)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s", o.remoteIp(r), user, start.Format(accessTimeFormat),
+		r.Method, r.URL.RequestURI(), r.Proto, status, size)
+	if o.Format == AccessFormatCombined {
+		line += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+	}
+	return line
+}
+
+func (o *AccessLogOptions) remoteIp(r *http.Request) string {
+	if o.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusWriter 记录状态码和写入的字节数
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	panicked    bool
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status, s.wroteHeader = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusWriter) statusCode() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		if !s.wroteHeader {
+			s.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := s.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker not supported")
+}
+
+// Unwrap 供http.ResponseController取出原始的ResponseWriter
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,374 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	AsyncPolicyBlock      = "block"       //缓冲满时阻塞调用方
+	AsyncPolicyDropNewest = "drop_newest" //缓冲满时丢弃新日志
+	AsyncPolicyDropLow    = "drop_low"    //缓冲满时优先丢弃缓冲中等级更低的日志 没有更低的则丢弃新日志
+
+	DefaultAsyncBufferSize    = 8192
+	DefaultAsyncBatchSize     = 256
+	DefaultAsyncFlushInterval = time.Second
+)
+
+var (
+	ErrAsyncClosed        = errors.New("async log file closed")
+	ErrUnknownAsyncPolicy = errors.New("unknown async policy")
+)
+
+// asyncDropped 所有异步文件按等级累计的丢弃行数
+var asyncDropped [FatalLevel + 1]int64
+
+// AsyncDropped 所有异步文件累计丢弃的行数
+func AsyncDropped() int64 {
+	var total int64
+	for i := range asyncDropped {
+		total += atomic.LoadInt64(&asyncDropped[i])
+	}
+	return total
+}
+
+// AsyncDroppedByLevel 所有异步文件某个等级累计丢弃的行数
+func AsyncDroppedByLevel(level int) int64 {
+	if level < DebugLevel || level > FatalLevel {
+		return 0
+	}
+	return atomic.LoadInt64(&asyncDropped[level])
+}
+
+type asyncLine struct {
+	level int
+	data  []byte
+}
+
+// AsyncLogFile 异步写文件 日志先放入有界环形缓冲 由后台协程批量写入底层文件
+// Exit时会把缓冲中的日志全部写完再关闭底层文件
+type AsyncLogFile struct {
+	file      LogFileWrite
+	policy    string
+	batchSize int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond //缓冲有空位 缓冲写完 关闭时广播
+	lines   []asyncLine
+	head    int
+	count   int
+	writing bool
+	closed  bool
+	batch   bytes.Buffer
+
+	wake    chan struct{}
+	done    chan struct{}
+	dropped [FatalLevel + 1]int64
+}
+
+var _ LogFileWrite = (*AsyncLogFile)(nil)
+
+// NewAsyncLogFile 把file包装成异步写 cfg为空或字段为0时使用默认值
+func NewAsyncLogFile(file LogFileWrite, cfg *config.Async) *AsyncLogFile {
+	if cfg == nil {
+		cfg = new(config.Async)
+	}
+	a := &AsyncLogFile{
+		file:      file,
+		policy:    strings.ToLower(cfg.Policy),
+		batchSize: cfg.BatchSize,
+		interval:  time.Duration(cfg.FlushInterval) * time.Millisecond,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	size := cfg.BufferSize
+	if size <= 0 {
+		size = DefaultAsyncBufferSize
+	}
+	if a.batchSize <= 0 {
+		a.batchSize = DefaultAsyncBatchSize
+	}
+	if a.batchSize > size {
+		a.batchSize = size
+	}
+	if a.interval <= 0 {
+		a.interval = DefaultAsyncFlushInterval
+	}
+	if a.policy == "" {
+		a.policy = AsyncPolicyBlock
+	}
+	a.lines = make([]asyncLine, size)
+	a.cond = sync.NewCond(&a.mu)
+	go a.run()
+	return a
+}
+
+// Write 没有传入等级时从内容中识别
+func (a *AsyncLogFile) Write(p []byte) (n int, err error) {
+	return a.WriteLevel(ParseLineLevel(p), p)
+}
+
+// WriteLevel 按传入的等级缓冲 缓冲满时drop_low按这个等级丢弃
+func (a *AsyncLogFile) WriteLevel(level int, p []byte) (n int, err error) {
+	if level < DebugLevel || level > FatalLevel {
+		level = InfoLevel
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for a.count == len(a.lines) && !a.closed {
+		switch a.policy {
+		case AsyncPolicyDropNewest:
+			a.drop(level)
+			return len(p), nil
+		case AsyncPolicyDropLow:
+			if !a.evictLower(level) {
+				a.drop(level)
+				return len(p), nil
+			}
+		default:
+			a.notify()
+			a.cond.Wait()
+		}
+	}
+	if a.closed {
+		return 0, ErrAsyncClosed
+	}
+	data := make([]byte, len(p)) //调用方会复用p 需要复制
+	copy(data, p)
+	a.lines[(a.head+a.count)%len(a.lines)] = asyncLine{level: level, data: data}
+	a.count++
+	if a.count >= a.batchSize {
+		a.notify()
+	}
+	return len(p), nil
+}
+
+// Flush 等待缓冲中的日志全部写入底层文件 再刷新底层文件
+func (a *AsyncLogFile) Flush() {
+	if a.drain() {
+		flushLogFile(a.file)
+	}
+}
+
+// Sync 等待缓冲中的日志全部写入后同步底层文件
+func (a *AsyncLogFile) Sync() error {
+	if !a.drain() {
+		return nil
+	}
+	return syncLogFile(a.file)
+}
+
+// drain 等待缓冲中的日志全部写入底层文件 已关闭时返回false
+func (a *AsyncLogFile) drain() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notify()
+	for (a.count > 0 || a.writing) && !a.closed {
+		a.cond.Wait()
+	}
+	return !a.closed
+}
+
+// Exit 写完缓冲中的日志后关闭底层文件 之后的写入返回ErrAsyncClosed
+func (a *AsyncLogFile) Exit() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.cond.Broadcast()
+	a.mu.Unlock()
+	a.notify()
+	<-a.done
+	return a.file.Exit()
+}
+
+// Dropped 本文件丢弃的行数
+func (a *AsyncLogFile) Dropped() int64 {
+	var total int64
+	for i := range a.dropped {
+		total += atomic.LoadInt64(&a.dropped[i])
+	}
+	return total
+}
+
+// DroppedByLevel 本文件某个等级丢弃的行数
+func (a *AsyncLogFile) DroppedByLevel(level int) int64 {
+	if level < DebugLevel || level > FatalLevel {
+		return 0
+	}
+	return atomic.LoadInt64(&a.dropped[level])
+}
+
+// Buffered 缓冲中还未写入的行数
+func (a *AsyncLogFile) Buffered() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.count
+}
+
+func (a *AsyncLogFile) notify() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *AsyncLogFile) drop(level int) {
+	atomic.AddInt64(&a.dropped[level], 1)
+	atomic.AddInt64(&asyncDropped[level], 1)
+}
+
+// evictLower 丢弃缓冲中等级最低且低于level的最旧一行 没有时返回false
+func (a *AsyncLogFile) evictLower(level int) bool {
+	size := len(a.lines)
+	idx, lowest := -1, level
+	for i := 0; i < a.count; i++ {
+		if lv := a.lines[(a.head+i)%size].level; lv < lowest {
+			idx, lowest = i, lv
+		}
+	}
+	if idx < 0 {
+		return false
+	}
+	for i := idx; i > 0; i-- { //把前面的日志后移一位 保持顺序
+		a.lines[(a.head+i)%size] = a.lines[(a.head+i-1)%size]
+	}
+	a.lines[a.head] = asyncLine{}
+	a.head = (a.head + 1) % size
+	a.count--
+	a.drop(lowest)
+	return true
+}
+
+func (a *AsyncLogFile) run() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.wake:
+		case <-ticker.C:
+		}
+		if !a.flushBatches() {
+			return
+		}
+	}
+}
+
+// flushBatches 按批写完缓冲中的日志 已关闭且写完时返回false
+// 同一批只包含相同等级的日志 写入底层文件时带上等级
+func (a *AsyncLogFile) flushBatches() bool {
+	for {
+		a.mu.Lock()
+		if a.count == 0 {
+			closed := a.closed
+			a.cond.Broadcast()
+			a.mu.Unlock()
+			return !closed
+		}
+		a.batch.Reset()
+		size := len(a.lines)
+		level := a.lines[a.head].level
+		for i := 0; i < a.batchSize && a.count > 0 && a.lines[a.head].level == level; i++ {
+			a.batch.Write(a.lines[a.head].data)
+			a.lines[a.head] = asyncLine{}
+			a.head = (a.head + 1) % size
+			a.count--
+		}
+		a.writing = true
+		a.cond.Broadcast()
+		a.mu.Unlock()
+
+		//batch只在本协程中使用 写文件时不持有锁
+		if _, err := writeLevel(a.file, level, a.batch.Bytes()); err != nil {
+			log.Println("xlog async write error", err.Error())
+		}
+
+		a.mu.Lock()
+		a.writing = false
+		a.mu.Unlock()
+	}
+}
+
+// ParseLineLevel 从一行日志中识别等级 支持json的"level":"xx"和logrus的"@lv":"xx" logrus文本的level=xx 以及制表符分隔的文本格式
+// 识别不出时按info处理 各后端写文件时通过LevelWriter传入等级 只有直接调用Write时才需要识别
+func ParseLineLevel(p []byte) int {
+	if i := bytes.Index(p, []byte(`"level":"`)); i >= 0 {
+		return lineLevel(p[i+len(`"level":"`):], '"')
+	}
+	if i := bytes.Index(p, []byte(`"@lv":"`)); i >= 0 {
+		return lineLevel(p[i+len(`"@lv":"`):], '"')
+	}
+	if i := bytes.Index(p, []byte("level=")); i >= 0 {
+		return lineLevel(p[i+len("level="):], ' ')
+	}
+	if i := bytes.IndexByte(p, PlainEncodingSep); i >= 0 {
+		return lineLevel(p[i+1:], PlainEncodingSep)
+	}
+	return InfoLevel
+}
+
+// 各后端json格式中内容 等级和时间的key
+var (
+	entryMessageKeys = []string{ContentKey, "msg", "@msg"}
+	entryLevelKeys   = []string{LevelKey, "@lv"}
+	entryTimeKeys    = []string{TimestampKey, "ts", "time", "@time"}
+)
+
+// parseLineEntry 解析json格式的一行日志 去掉时间 取出等级和内容 剩下的为字段
+// 不是json时ok为false 只识别等级
+func parseLineEntry(line []byte) (level int, msg interface{}, fields map[string]interface{}, ok bool) {
+	i := bytes.IndexByte(line, '{')
+	if i < 0 {
+		return ParseLineLevel(line), nil, nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(line[i:]))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil || fields == nil {
+		return ParseLineLevel(line), nil, nil, false
+	}
+	level = InfoLevel
+	for _, key := range entryLevelKeys {
+		if v, ok := fields[key].(string); ok {
+			level = lineLevel([]byte(v), '"')
+			delete(fields, key)
+			break
+		}
+	}
+	for _, key := range entryMessageKeys {
+		if v, ok := fields[key]; ok {
+			msg = v
+			delete(fields, key)
+			break
+		}
+	}
+	for _, key := range entryTimeKeys {
+		delete(fields, key)
+	}
+	return level, msg, fields, true
+}
+
+func lineLevel(p []byte, sep byte) int {
+	if i := bytes.IndexByte(p, sep); i >= 0 {
+		p = p[:i]
+	}
+	name := strings.ToLower(string(bytes.TrimSpace(p)))
+	if name == "warning" {
+		name = LevelWarn
+	}
+	if lv, ok := LogLevel[name]; ok {
+		return lv
+	}
+	return InfoLevel
+}
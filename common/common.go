@@ -41,9 +41,12 @@ func getClientIp() string {
 }
 
 func LogConfigCheck(config *config.LogConfig) error {
-	if config.LogName == "" && config.LogDir == "" && !config.IsConsole {
+	if config.LogName == "" && config.LogDir == "" && !config.IsConsole && config.Network == nil && config.Syslog == nil && config.Http == nil {
 		return errors.New("log config output set error")
 	}
+	if config.LogName == "" && config.LogDir == "" && (config.Network != nil || config.Syslog != nil || config.Http != nil) { //网络输出时log_name用于spool等文件名
+		config.LogName = "app"
+	}
 
 	if config.LogDir == "" && config.LogName != "" {
 		config.LogDir = "./log"
@@ -126,14 +129,24 @@ func IsPathExist(path string) (bool, error) {
 }
 
 func ParserYamlData(path string, config interface{}) { //config 必须是个指针对象
-	yamlFile, err := ioutil.ReadFile(path)
+	err := ReadYamlData(path, config)
 	if err != nil {
 		panic(err)
 	}
-	err = yaml.Unmarshal(yamlFile, config)
+}
+
+// ReadYamlData 同ParserYamlData 出错时返回错误
+func ReadYamlData(path string, config interface{}) error { //config 必须是个指针对象
+	yamlFile, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return yaml.Unmarshal(yamlFile, config)
+}
+
+// HasTimePlaceholder 按时间切分的日志名必须包含时间占位符
+func HasTimePlaceholder(name string) bool {
+	return strings.Contains(name, "$ti") || strings.Contains(name, "$day") || strings.Contains(name, "$hour") || strings.Contains(name, "$minute") || strings.Contains(name, "%")
 }
 
 func String2Bytes(s string) []byte {
@@ -175,14 +188,35 @@ func getRandString(n int) string {
 }
 
 func ReplaceLogName(name string) error {
-	if strings.Contains(name, LogTemp) {
-		newName := strings.ReplaceAll(name, LogTemp, LogFormal)
-		err := os.Rename(name, newName)
+	_, err := RenameLogFile(name)
+	return err
+}
+
+// RenameLogFile 把.temp改成.log 返回改名后的文件名
+// 目标文件已存在时不覆盖 在.log前依次加上_1 _2...直到不重名 如固定文件名重新打开后再次改名
+func RenameLogFile(name string) (string, error) {
+	if !strings.Contains(name, LogTemp) {
+		return name, nil
+	}
+	newName := strings.ReplaceAll(name, LogTemp, LogFormal)
+	prefix, suffix := newName, ""
+	if i := strings.LastIndex(newName, LogFormal); i >= 0 {
+		prefix, suffix = newName[:i], newName[i:]
+	}
+	for i := 1; ; i++ {
+		_, err := os.Lstat(newName)
+		if os.IsNotExist(err) {
+			break
+		}
 		if err != nil {
-			return err
+			return name, err
 		}
+		newName = fmt.Sprintf("%s_%d%s", prefix, i, suffix)
 	}
-	return nil
+	if err := os.Rename(name, newName); err != nil {
+		return name, err
+	}
+	return newName, nil
 }
 
 func GetBaseLogConfig() *config.LogConfig {
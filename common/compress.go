@@ -0,0 +1,139 @@
+package common
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	CompressGzip    = "gzip"
+	CompressDeflate = "deflate"
+	CompressZstd    = "zstd" //需要调用RegisterCompressor注册实现
+	CompressXz      = "xz"   //需要调用RegisterCompressor注册实现
+)
+
+var ErrUnknownCompressor = errors.New("unknown compressor")
+
+// Compressor 切分后旧文件的压缩方式 lumberjack和rotatelogs共用
+type Compressor interface {
+	// Suffix 压缩后文件名追加的后缀 如.gz
+	Suffix() string
+	// NewWriter 压缩后写入w 关闭返回的Writer时写完剩余数据 不会关闭w
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// CompressorFactory 按压缩等级创建Compressor level为0时使用默认等级
+type CompressorFactory func(level int) (Compressor, error)
+
+type compressorEntry struct {
+	suffix  string
+	factory CompressorFactory
+}
+
+var (
+	compressorMu sync.RWMutex
+	compressors  = map[string]compressorEntry{
+		CompressGzip:    {suffix: ".gz", factory: NewGzipCompressor},
+		CompressDeflate: {suffix: ".deflate", factory: NewDeflateCompressor},
+	}
+)
+
+// RegisterCompressor 注册压缩方式 name为配置中的codec suffix为压缩后文件的后缀
+// 注册后清理旧文件时能识别该后缀 同名时覆盖 如注册基于第三方库的zstd和xz
+func RegisterCompressor(name, suffix string, factory CompressorFactory) {
+	compressorMu.Lock()
+	defer compressorMu.Unlock()
+	compressors[strings.ToLower(name)] = compressorEntry{suffix: suffix, factory: factory}
+}
+
+// NewCompressor 按名字创建Compressor name为空时使用gzip
+func NewCompressor(name string, level int) (Compressor, error) {
+	if name == "" {
+		name = CompressGzip
+	}
+	compressorMu.RLock()
+	entry, ok := compressors[strings.ToLower(name)]
+	compressorMu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownCompressor
+	}
+	return entry.factory(level)
+}
+
+// CompressSuffixes 所有已注册压缩方式的后缀 长的在前
+func CompressSuffixes() []string {
+	compressorMu.RLock()
+	defer compressorMu.RUnlock()
+	suffixes := make([]string, 0, len(compressors))
+	for _, entry := range compressors {
+		suffixes = append(suffixes, entry.suffix)
+	}
+	sort.Slice(suffixes, func(i, j int) bool {
+		if len(suffixes[i]) != len(suffixes[j]) {
+			return len(suffixes[i]) > len(suffixes[j])
+		}
+		return suffixes[i] < suffixes[j]
+	})
+	return suffixes
+}
+
+// TrimCompressSuffix 去掉已注册的压缩后缀 没有时ok为false
+func TrimCompressSuffix(name string) (string, bool) {
+	for _, suffix := range CompressSuffixes() {
+		if suffix != "" && strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+	return name, false
+}
+
+type gzipCompressor struct {
+	level int
+}
+
+// NewGzipCompressor level为1-9 0为默认等级
+func NewGzipCompressor(level int) (Compressor, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return nil, err
+	}
+	return gzipCompressor{level: level}, nil
+}
+
+func (g gzipCompressor) Suffix() string {
+	return ".gz"
+}
+
+func (g gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, g.level)
+}
+
+type deflateCompressor struct {
+	level int
+}
+
+// NewDeflateCompressor 不带gzip头的deflate流 level为1-9 0为默认等级
+func NewDeflateCompressor(level int) (Compressor, error) {
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, err
+	}
+	return deflateCompressor{level: level}, nil
+}
+
+func (d deflateCompressor) Suffix() string {
+	return ".deflate"
+}
+
+func (d deflateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, d.level)
+}
@@ -0,0 +1,48 @@
+package xlog
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/crx666/xlog/common"
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	CompressGzip    = common.CompressGzip
+	CompressDeflate = common.CompressDeflate
+	CompressZstd    = common.CompressZstd
+	CompressXz      = common.CompressXz
+)
+
+var (
+	ErrUnknownCompressor    = common.ErrUnknownCompressor
+	ErrInvalidCompressLevel = errors.New("invalid compress level")
+)
+
+// Compressor rotatelog和lumberjack切分后旧文件的压缩方式
+type Compressor = common.Compressor
+
+// CompressorFactory 按压缩等级创建Compressor level为0时使用默认等级
+type CompressorFactory = common.CompressorFactory
+
+// RegisterCompressor 注册压缩方式 之后可以在compression.codec中使用 如基于第三方库的zstd和xz
+// suffix需要和Compressor.Suffix一致 清理旧文件时按它识别压缩后的文件 需要在加载配置前调用
+func RegisterCompressor(name, suffix string, factory CompressorFactory) {
+	common.RegisterCompressor(name, suffix, factory)
+}
+
+// newCompressor 没有配置时返回nil 使用默认的gzip
+func newCompressor(cfg *config.Compression) (Compressor, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	c, err := common.NewCompressor(cfg.Codec, cfg.Level)
+	if errors.Is(err, common.ErrUnknownCompressor) {
+		return nil, newConfigError("compression.codec", cfg.Codec, err)
+	}
+	if err != nil {
+		return nil, newConfigError("compression.level", cfg.Level, fmt.Errorf("%w: %s", ErrInvalidCompressLevel, err.Error()))
+	}
+	return c, nil
+}
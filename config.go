@@ -0,0 +1,207 @@
+package xlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/crx666/xlog/common"
+	"github.com/crx666/xlog/config"
+)
+
+var (
+	ErrSplitConflict       = errors.New("rotatelog and lumberjack can not be set at the same time")
+	ErrNoTimePlaceholder   = errors.New("split time log file need time format. please use $ti or $day or $hour or $minute or strftime format like %Y%m%d of logName")
+	ErrInvalidTimeFormat   = errors.New("invalid strftime format")
+	ErrNoSplitTime         = errors.New("split_day split_hour split_minute are all zero")
+	ErrNegativeValue       = errors.New("value can not be negative")
+	ErrUnknownLevel        = errors.New("unknown log level")
+	ErrUnknownBackend      = errors.New("unknown log backend")
+	ErrUnknownEncoding     = errors.New("unknown log encoding")
+	ErrOpenLogFile         = errors.New("open log file failed")
+	ErrReadConfig          = errors.New("read log config failed")
+	ErrInvalidOutputConfig = errors.New("log config output set error")
+	ErrUnknownLocation     = errors.New("unknown time location")
+	ErrInvalidDuration     = errors.New("invalid duration")
+)
+
+// ConfigError 配置错误 Field为yaml中的字段名 可用errors.Is判断具体错误类型
+type ConfigError struct {
+	Field string
+	Value interface{}
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("log config: %s", e.Err.Error())
+	}
+	return fmt.Sprintf("log config %s=%v: %s", e.Field, e.Value, e.Err.Error())
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func newConfigError(field string, value interface{}, err error) error {
+	return &ConfigError{Field: field, Value: value, Err: err}
+}
+
+// LoadLogConfig 读取并检查日志配置 不会panic
+func LoadLogConfig(path string) (*config.LogConfig, error) {
+	cfg := new(config.LogConfig)
+	if err := common.ReadYamlData(path, cfg); err != nil {
+		return nil, newConfigError("path", path, fmt.Errorf("%w: %s", ErrReadConfig, err.Error()))
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ApplyConfig 检查配置并应用到默认日志对象 出错时返回错误不panic
+func ApplyConfig(cfg *config.LogConfig) error {
+	return GetWriter().ApplyConfig(cfg)
+}
+
+// ValidateConfig 检查完整配置 会和LogConfigCheck一样补全默认的log_dir和log_name
+func ValidateConfig(cfg *config.LogConfig) error {
+	if cfg == nil {
+		return newConfigError("", nil, ErrInvalidOutputConfig)
+	}
+	if err := common.LogConfigCheck(cfg); err != nil {
+		return newConfigError("", nil, fmt.Errorf("%w: %v", ErrInvalidOutputConfig, err))
+	}
+	if _, ok := LogLevel[strings.ToLower(cfg.LogLevel)]; !ok && cfg.LogLevel != "" {
+		return newConfigError("log_level", cfg.LogLevel, ErrUnknownLevel)
+	}
+	switch strings.ToLower(cfg.Backend) {
+	case "", BackendZap, BackendLogrus, BackendStd:
+	default:
+		return newConfigError("backend", cfg.Backend, ErrUnknownBackend)
+	}
+	if _, err := parseEncoding(cfg.Encoding); err != nil {
+		return newConfigError("encoding", cfg.Encoding, ErrUnknownEncoding)
+	}
+	if cfg.Rotatelog != nil && cfg.Lumberjack != nil {
+		return newConfigError("rotatelog", "lumberjack", ErrSplitConflict)
+	}
+	if cfg.Http != nil {
+		if cfg.Syslog != nil || cfg.Network != nil || cfg.Rotatelog != nil || cfg.Lumberjack != nil {
+			return newConfigError("http", cfg.Http.Url, ErrHttpConflict)
+		}
+		if err := validateHttp(cfg.Http); err != nil {
+			return err
+		}
+	}
+	if cfg.Syslog != nil {
+		if cfg.Network != nil || cfg.Rotatelog != nil || cfg.Lumberjack != nil {
+			return newConfigError("syslog", cfg.Syslog.Address, ErrSyslogConflict)
+		}
+		if err := validateSyslog(cfg.Syslog); err != nil {
+			return err
+		}
+	}
+	if cfg.Network != nil {
+		if cfg.Rotatelog != nil || cfg.Lumberjack != nil {
+			return newConfigError("network", cfg.Network.Address, ErrNetworkConflict)
+		}
+		if err := validateNetwork(cfg.Network); err != nil {
+			return err
+		}
+	}
+	if r := cfg.Rotatelog; r != nil {
+		if err := checkNegative(
+			intField{"rotatelog.max_save", r.MaxSave},
+			intField{"rotatelog.split_day", r.SplitDay},
+			intField{"rotatelog.split_hour", r.SplitHour},
+			intField{"rotatelog.split_minute", r.SplitMinute},
+			intField{"rotatelog.split_offset", r.SplitOffset},
+			intField{"rotatelog.max_total_size", r.MaxTotalSize},
+		); err != nil {
+			return err
+		}
+		if _, err := rotateMaxAge(r); err != nil {
+			return err
+		}
+		if _, err := rotateLocation(r.Location); err != nil {
+			return err
+		}
+		if r.SplitDay == 0 && r.SplitHour == 0 && r.SplitMinute == 0 {
+			return newConfigError("rotatelog", "", ErrNoSplitTime)
+		}
+		if cfg.LogName != "" {
+			if err := checkRotateName("log_name", cfg.LogName); err != nil {
+				return err
+			}
+		}
+		if cfg.ErrLogName != "" {
+			if err := checkRotateName("err_log_name", cfg.ErrLogName); err != nil {
+				return err
+			}
+		}
+	}
+	if l := cfg.Lumberjack; l != nil {
+		if err := checkNegative(
+			intField{"lumberjack.max_size", l.MaxSize},
+			intField{"lumberjack.max_backups", l.MaxBackups},
+			intField{"lumberjack.max_age", l.MaxAge},
+			intField{"lumberjack.split_time", l.SplitTime},
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := newCompressor(cfg.Compression); err != nil {
+		return err
+	}
+	if cfg.Redact != nil {
+		if _, err := NewRedactor(cfg.Redact); err != nil {
+			return err
+		}
+	}
+	if s := cfg.Sampling; s != nil {
+		if err := checkNegative(
+			intField{"sampling.interval", s.Interval},
+			intField{"sampling.first", s.First},
+			intField{"sampling.thereafter", s.Thereafter},
+			intField{"sampling.rate_limit", s.RateLimit},
+			intField{"sampling.burst", s.Burst},
+		); err != nil {
+			return err
+		}
+	}
+	if d := cfg.Dedup; d != nil {
+		if err := checkNegative(intField{"dedup.window", d.Window}); err != nil {
+			return err
+		}
+	}
+	if a := cfg.Async; a != nil {
+		if err := checkNegative(
+			intField{"async.buffer_size", a.BufferSize},
+			intField{"async.batch_size", a.BatchSize},
+			intField{"async.flush_interval", a.FlushInterval},
+		); err != nil {
+			return err
+		}
+		switch strings.ToLower(a.Policy) {
+		case "", AsyncPolicyBlock, AsyncPolicyDropNewest, AsyncPolicyDropLow:
+		default:
+			return newConfigError("async.policy", a.Policy, ErrUnknownAsyncPolicy)
+		}
+	}
+	return nil
+}
+
+type intField struct {
+	name  string
+	value int
+}
+
+func checkNegative(fields ...intField) error {
+	for _, field := range fields {
+		if field.value < 0 {
+			return newConfigError(field.name, field.value, ErrNegativeValue)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,22 @@
 package config
 
 type Rotatelog struct {
-	MaxSave     int    `json:"max_save" yaml:"max_save"`         //文件最大保存分数
-	SplitDay    int    `json:"split_day" yaml:"split_day"`       //日志切割时间  单位:天
-	SplitHour   int    `json:"split_hour" yaml:"split_hour"`     //日志切割时间  单位:小时
-	SplitMinute int    `json:"split_minute" yaml:"split_minute"` //日志切割时间  单位:分钟
-	LinkName    string `json:"link_name" yaml:"link_name"`       //是否需要软连接
+	MaxSave      int    `json:"max_save" yaml:"max_save"`             //文件最大保存分数
+	MaxAge       string `json:"max_age" yaml:"max_age"`               //文件最长保存时间 如72h 7d 按文件名中的时间计算 max_age max_save max_total_size都不配置时保存7天
+	MaxTotalSize int    `json:"max_total_size" yaml:"max_total_size"` //所有文件的总大小上限 超过时删除最旧的文件 单位:MB
+	SplitDay     int    `json:"split_day" yaml:"split_day"`           //日志切割时间  单位:天
+	SplitHour    int    `json:"split_hour" yaml:"split_hour"`         //日志切割时间  单位:小时
+	SplitMinute  int    `json:"split_minute" yaml:"split_minute"`     //日志切割时间  单位:分钟
+	LinkName     string `json:"link_name" yaml:"link_name"`           //是否需要软连接
+	SplitOffset  int    `json:"split_offset" yaml:"split_offset"`     //切割时间点的偏移 单位:分钟 如split_day为1 split_offset为240时每天4点切割
+	Location     string `json:"location" yaml:"location"`             //切割时间点和文件名使用的时区 如UTC Asia/Shanghai 为空使用本地时区
+	Compress     bool   `json:"compress" yaml:"compress"`             //切割后是否在后台gzip压缩
+}
+
+// Compression rotatelog和lumberjack开启compress时使用的压缩方式
+type Compression struct {
+	Codec string `json:"codec" yaml:"codec"` //gzip deflate 或通过xlog.RegisterCompressor注册的zstd xz等 默认gzip
+	Level int    `json:"level" yaml:"level"` //压缩等级 gzip和deflate为1-9 0为默认等级
 }
 
 type Lumberjack struct {
@@ -16,19 +27,103 @@ type Lumberjack struct {
 	SplitTime  int  `json:"split_time" yaml:"split_time"`   //定时分割  单位:分钟
 }
 
+type Async struct {
+	BufferSize    int    `json:"buffer_size" yaml:"buffer_size"`       //缓冲的最大行数 默认8192
+	BatchSize     int    `json:"batch_size" yaml:"batch_size"`         //单次写文件的最大行数 默认256
+	FlushInterval int    `json:"flush_interval" yaml:"flush_interval"` //定时写文件间隔 单位:毫秒 默认1000
+	Policy        string `json:"policy" yaml:"policy"`                 //缓冲满时的策略 block drop_newest drop_low 默认block
+}
+
+type Redact struct {
+	Keys     []string `json:"keys" yaml:"keys"`         //需要脱敏的字段名 不区分大小写 支持*通配 如password *_token
+	Patterns []string `json:"patterns" yaml:"patterns"` //对日志内容脱敏的正则 匹配部分被替换
+	Mask     string   `json:"mask" yaml:"mask"`         //替换后的内容 默认******
+}
+
+type Sampling struct {
+	Interval   int `json:"interval" yaml:"interval"`     //采样周期 单位:毫秒 默认1000
+	First      int `json:"first" yaml:"first"`           //每个周期内相同等级和内容的前N条全部打印 0代表不采样
+	Thereafter int `json:"thereafter" yaml:"thereafter"` //超过N条后每M条打印一条 0代表全部丢弃
+	RateLimit  int `json:"rate_limit" yaml:"rate_limit"` //每秒最多打印的行数 0代表不限制
+	Burst      int `json:"burst" yaml:"burst"`           //令牌桶容量 默认等于rate_limit
+}
+
+type NetworkTLS struct {
+	CaFile             string `json:"ca_file" yaml:"ca_file"`                           //校验服务端证书的CA 为空时使用系统CA
+	CertFile           string `json:"cert_file" yaml:"cert_file"`                       //客户端证书 双向认证时使用
+	KeyFile            string `json:"key_file" yaml:"key_file"`                         //客户端私钥
+	ServerName         string `json:"server_name" yaml:"server_name"`                   //校验的服务端名字 默认取address中的host
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"` //不校验服务端证书
+}
+
+type Network struct {
+	Protocol     string      `json:"protocol" yaml:"protocol"`             //tcp udp unix unixgram 默认tcp
+	Address      string      `json:"address" yaml:"address"`               //host:port 或unix socket路径
+	Tls          *NetworkTLS `json:"tls" yaml:"tls"`                       //只支持tcp 为空时不加密
+	DialTimeout  int         `json:"dial_timeout" yaml:"dial_timeout"`     //连接超时 单位:毫秒 默认3000
+	WriteTimeout int         `json:"write_timeout" yaml:"write_timeout"`   //写超时 单位:毫秒 默认3000
+	MinBackoff   int         `json:"min_backoff" yaml:"min_backoff"`       //断线后首次重连的间隔 单位:毫秒 默认500 之后每次翻倍
+	MaxBackoff   int         `json:"max_backoff" yaml:"max_backoff"`       //重连间隔的上限 单位:毫秒 默认30000
+	Spool        bool        `json:"spool" yaml:"spool"`                   //断线时写到log_dir下的 log_name.spool 重连后和下次启动时重放 文件名去掉$ti和$rand
+	SpoolMaxSize int         `json:"spool_max_size" yaml:"spool_max_size"` //spool文件的最大大小 单位:MB 默认100 超过后丢弃
+}
+
+type Syslog struct {
+	Protocol string      `json:"protocol" yaml:"protocol"` //unixgram udp tcp 默认unixgram
+	Address  string      `json:"address" yaml:"address"`   //默认/dev/log
+	Tls      *NetworkTLS `json:"tls" yaml:"tls"`           //只支持tcp 为空时不加密
+	Format   string      `json:"format" yaml:"format"`     //rfc5424 rfc3164 默认rfc5424
+	Facility string      `json:"facility" yaml:"facility"` //kern user daemon local0-local7等 默认user
+	AppName  string      `json:"app_name" yaml:"app_name"` //默认进程名
+	Hostname string      `json:"hostname" yaml:"hostname"` //默认本机名
+	SdId     string      `json:"sd_id" yaml:"sd_id"`       //字段转成structured data时的SD-ID 默认fields@32473
+}
+
+type Http struct {
+	Url           string            `json:"url" yaml:"url"`                       //elasticsearch为.../_bulk loki为.../loki/api/v1/push otlp为.../v1/logs
+	Format        string            `json:"format" yaml:"format"`                 //elasticsearch loki otlp 默认elasticsearch
+	Index         string            `json:"index" yaml:"index"`                   //elasticsearch的索引 默认xlog
+	Labels        map[string]string `json:"labels" yaml:"labels"`                 //loki的stream标签 会自动加上level otlp的resource属性 默认带上service.name host.name process.pid
+	Headers       map[string]string `json:"headers" yaml:"headers"`               //额外的请求头 如Authorization
+	BatchSize     int               `json:"batch_size" yaml:"batch_size"`         //单批最大行数 默认500
+	BatchBytes    int               `json:"batch_bytes" yaml:"batch_bytes"`       //单批最大大小 单位:KB 默认1024
+	FlushInterval int               `json:"flush_interval" yaml:"flush_interval"` //不满一批时的发送间隔 单位:毫秒 默认1000
+	Gzip          bool              `json:"gzip" yaml:"gzip"`                     //是否gzip压缩请求
+	Timeout       int               `json:"timeout" yaml:"timeout"`               //单次请求超时 单位:毫秒 默认10000
+	MaxRetries    int               `json:"max_retries" yaml:"max_retries"`       //失败后的最大重试次数 默认3
+	MinBackoff    int               `json:"min_backoff" yaml:"min_backoff"`       //首次重试的间隔 单位:毫秒 默认500 之后每次翻倍
+	MaxBackoff    int               `json:"max_backoff" yaml:"max_backoff"`       //重试间隔的上限 单位:毫秒 默认10000
+	DeadLetter    bool              `json:"dead_letter" yaml:"dead_letter"`       //重试用完后写到log_dir下的log_name.dead
+}
+
+type Dedup struct {
+	Window int `json:"window" yaml:"window"` //合并重复日志的周期 单位:毫秒 默认10000
+}
+
 type LogConfig struct {
-	LogDir     string      `json:"log_dir" yaml:"log_dir"`           //日志路径
-	LogName    string      `json:"log_name" yaml:"log_name"`         //正常打印日志文件名字
-	ErrLogName string      `json:"err_log_name" yaml:"err_log_name"` //错误日志文件名字  为空时代表 正常打印和错误打印在同一个文件
-	LogLevel   string      `json:"log_level" yaml:"log_level"`       //日志打印等级 debug info
-	IsProd     bool        `json:"is_prod" yaml:"is_prod"`           //是否正式服
-	IsConsole  bool        `json:"is_console" yaml:"is_console"`     //是否控制台打印
-	IsCall     bool        `json:"is_call" yaml:"is_call"`           //是否需要调用行数打印
-	Rotatelog  *Rotatelog  `json:"rotatelog" yaml:"rotatelog"`       //按时间切分日志
-	Lumberjack *Lumberjack `json:"lumberjack" yaml:"lumberjack"`     //按日志大小切分日志
-	LogMark    string      `json:"log_mark" yaml:"log_mark"`         //日志标记
+	LogDir      string       `json:"log_dir" yaml:"log_dir"`           //日志路径
+	LogName     string       `json:"log_name" yaml:"log_name"`         //正常打印日志文件名字
+	ErrLogName  string       `json:"err_log_name" yaml:"err_log_name"` //错误日志文件名字  为空时代表 正常打印和错误打印在同一个文件
+	LogLevel    string       `json:"log_level" yaml:"log_level"`       //日志打印等级 debug info
+	IsProd      bool         `json:"is_prod" yaml:"is_prod"`           //是否正式服
+	IsConsole   bool         `json:"is_console" yaml:"is_console"`     //是否控制台打印
+	IsCall      bool         `json:"is_call" yaml:"is_call"`           //是否需要调用行数打印
+	Rotatelog   *Rotatelog   `json:"rotatelog" yaml:"rotatelog"`       //按时间切分日志
+	Lumberjack  *Lumberjack  `json:"lumberjack" yaml:"lumberjack"`     //按日志大小切分日志
+	Compression *Compression `json:"compression" yaml:"compression"`   //切分后旧文件的压缩方式 为空时使用gzip
+	Network     *Network     `json:"network" yaml:"network"`           //通过网络发送日志 不再写本地文件
+	Syslog      *Syslog      `json:"syslog" yaml:"syslog"`             //发送到syslog 不再写本地文件
+	Http        *Http        `json:"http" yaml:"http"`                 //批量发送到elasticsearch loki或otlp 不再写本地文件
+	Async       *Async       `json:"async" yaml:"async"`               //异步写文件 为空时同步写
+	Redact      *Redact      `json:"redact" yaml:"redact"`             //敏感信息脱敏 为空时不处理
+	Sampling    *Sampling    `json:"sampling" yaml:"sampling"`         //采样和限流 为空时不处理
+	Dedup       *Dedup       `json:"dedup" yaml:"dedup"`               //合并连续重复的日志 为空时不处理
+	LogMark     string       `json:"log_mark" yaml:"log_mark"`         //日志标记
+	Backend     string       `json:"backend" yaml:"backend"`           //日志后端 zap logrus std 默认zap
+	Encoding    string       `json:"encoding" yaml:"encoding"`         //输出格式 json text 默认json
 }
 
 type RepeateConfig struct {
 	Configs []*LogConfig `json:"configs" yaml:"configs"`
+	Default string       `json:"default" yaml:"default"` //作为默认日志对象的log_mark 为空时不设置
 }
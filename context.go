@@ -0,0 +1,120 @@
+package xlog
+
+import (
+	"context"
+	"sync"
+)
+
+// ContextExtractor 从context中提取需要打印的字段
+type ContextExtractor func(ctx context.Context) []LogField
+
+type contextFieldsKey struct{}
+
+var ctxExtractors = newContextExtractors()
+
+type contextExtractors struct {
+	sync.RWMutex
+	names      []string
+	extractors map[string]ContextExtractor
+}
+
+func newContextExtractors() *contextExtractors {
+	c := &contextExtractors{
+		extractors: make(map[string]ContextExtractor),
+	}
+	c.names = append(c.names, "fields", "trace")
+	c.extractors["fields"] = boundContextFields
+	c.extractors["trace"] = traceContextFields
+	return c
+}
+
+func (c *contextExtractors) add(name string, fn ContextExtractor) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.extractors[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.extractors[name] = fn
+}
+
+func (c *contextExtractors) remove(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.extractors[name]; !ok {
+		return
+	}
+	delete(c.extractors, name)
+	for i, n := range c.names {
+		if n == name {
+			c.names = append(c.names[:i:i], c.names[i+1:]...)
+			break
+		}
+	}
+}
+
+func (c *contextExtractors) extract(ctx context.Context) []LogField {
+	c.RLock()
+	defer c.RUnlock()
+	var fields []LogField
+	for _, name := range c.names { //按注册顺序提取 保证输出稳定
+		fields = append(fields, c.extractors[name](ctx)...)
+	}
+	return fields
+}
+
+// RegisterContextExtractor 注册context字段提取器 同名覆盖
+func RegisterContextExtractor(name string, fn ContextExtractor) {
+	if fn == nil {
+		return
+	}
+	ctxExtractors.add(name, fn)
+}
+
+// UnregisterContextExtractor 删除context字段提取器
+func UnregisterContextExtractor(name string) {
+	ctxExtractors.remove(name)
+}
+
+// ContextWithFields 把字段绑定到context上 后续Ctx系列打印会自动带上
+func ContextWithFields(ctx context.Context, fields ...LogField) context.Context {
+	if len(fields) <= 0 {
+		return ctx
+	}
+	old, _ := ctx.Value(contextFieldsKey{}).([]LogField)
+	newFields := make([]LogField, 0, len(old)+len(fields))
+	newFields = append(newFields, old...)
+	newFields = append(newFields, fields...)
+	return context.WithValue(ctx, contextFieldsKey{}, newFields)
+}
+
+// ContextWithLogFields 同ContextWithFields 参数为LogFields
+func ContextWithLogFields(ctx context.Context, fields ...LogFields) context.Context {
+	return ContextWithFields(ctx, Fields(fields...)...)
+}
+
+// FieldsFromContext 返回所有提取器从context中得到的字段
+func FieldsFromContext(ctx context.Context) []LogField {
+	if ctx == nil {
+		return nil
+	}
+	return ctxExtractors.extract(ctx)
+}
+
+func boundContextFields(ctx context.Context) []LogField {
+	fields, _ := ctx.Value(contextFieldsKey{}).([]LogField)
+	return fields
+}
+
+func withContextFields(ctx context.Context, fields ...LogField) []LogField {
+	ctxFields := FieldsFromContext(ctx)
+	if len(ctxFields) <= 0 {
+		return fields
+	}
+	if len(fields) <= 0 {
+		return ctxFields
+	}
+	values := make([]LogField, 0, len(ctxFields)+len(fields))
+	values = append(values, ctxFields...)
+	values = append(values, fields...)
+	return values
+}
@@ -0,0 +1,130 @@
+package xlog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	DefaultDedupWindow = 10 * time.Second
+
+	RepeatCountKey = "repeat_count" //重复的次数 不包含第一次打印的那条
+	FirstSeenKey   = "first_seen"   //第一次出现的时间
+	LastSeenKey    = "last_seen"    //最后一次重复的时间
+)
+
+type dedupEntry struct {
+	key   string
+	emit  func(fields ...LogField) //打印这条日志 fields为附加的汇总字段
+	first time.Time
+	last  time.Time
+	count int64
+}
+
+// deduper 折叠连续重复的日志 由派生的子logger共享 std在打印时调用 zap和logrus分别在core和写文件的hook中调用
+// 等级 内容和字段都相同的连续日志只打印第一条 之后的重复在出现不同日志 周期结束或关闭时
+// 合并成一条带repeat_count first_seen last_seen的日志 类似syslog的last message repeated N times
+type deduper struct {
+	mu     sync.Mutex
+	active int32
+	window time.Duration
+	last   dedupEntry
+	timer  *time.Timer
+}
+
+func newDeduper() *deduper {
+	return &deduper{window: DefaultDedupWindow}
+}
+
+// apply 应用新的去重配置 未打印的重复先打印
+func (d *deduper) apply(cfg *config.Dedup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flushLocked()
+	d.window = DefaultDedupWindow
+	if cfg != nil && cfg.Window > 0 {
+		d.window = time.Duration(cfg.Window) * time.Millisecond
+	}
+	atomic.StoreInt32(&d.active, boolToInt32(cfg != nil))
+}
+
+func (d *deduper) enabled() bool {
+	return d != nil && atomic.LoadInt32(&d.active) == 1
+}
+
+// log 打印一条日志 key与上一条相同且在周期内时只计数
+// 写入在锁内进行 保证汇总在下一条不同的日志之前
+func (d *deduper) log(key string, emit func(fields ...LogField)) {
+	if !d.enabled() {
+		emit()
+		return
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last.emit != nil && d.last.key == key && now.Sub(d.last.first) < d.window {
+		d.last.count++
+		d.last.last = now
+		if d.timer == nil {
+			entry := d.last.first
+			d.timer = time.AfterFunc(d.last.first.Add(d.window).Sub(now), func() {
+				d.closeWindow(entry)
+			})
+		}
+		return
+	}
+	d.flushLocked()
+	d.last = dedupEntry{key: key, emit: emit, first: now, last: now}
+	emit()
+}
+
+// flush 打印未打印的重复
+func (d *deduper) flush() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flushLocked()
+}
+
+// closeWindow 周期结束 first仍是当前日志时才打印
+func (d *deduper) closeWindow(first time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.last.emit == nil || !d.last.first.Equal(first) {
+		return
+	}
+	d.flushLocked()
+}
+
+func (d *deduper) flushLocked() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	e := d.last
+	d.last = dedupEntry{}
+	if e.emit == nil || e.count <= 0 {
+		return
+	}
+	e.emit(Field(RepeatCountKey, e.count),
+		Field(FirstSeenKey, e.first.Format(TimeFormat)), Field(LastSeenKey, e.last.Format(TimeFormat)))
+}
+
+func dedupKey(level string, val interface{}, fields []LogField) string {
+	var buf strings.Builder
+	buf.WriteString(level)
+	buf.WriteByte(PlainEncodingSep)
+	fmt.Fprint(&buf, val)
+	for _, field := range fields {
+		buf.WriteByte(PlainEncodingSep)
+		fmt.Fprintf(&buf, "%s=%v", field.Key, field.Value)
+	}
+	return buf.String()
+}
@@ -1,12 +1,34 @@
 package xlog
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/crx666/xlog/config"
+	"github.com/crx666/xlog/lumberjack"
+	"github.com/crx666/xlog/rotatelogs"
+	"github.com/jonboulle/clockwork"
 
 	"github.com/crx666/xlog/common"
 
@@ -28,6 +50,114 @@ func TestConfigs(t *testing.T) {
 	fmt.Println(c.Configs[0], c.Configs[1])
 }
 
+func TestLoadConfigs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "repeate_log.yaml")
+	content := fmt.Sprintf(`configs:
+  - log_mark: "load_zap"
+    backend: "zap"
+    log_dir: %[1]q
+    log_name: "zap"
+  - log_mark: "load_std"
+    backend: "std"
+    encoding: "text"
+    log_dir: %[1]q
+    log_name: "std"
+  - log_mark: "load_zap"
+    backend: "logrus"
+    log_dir: %[1]q
+    log_name: "logrus"
+`, dir)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigs(path); !errors.Is(err, ErrRepeatedLogMark) {
+		t.Fatalf("duplicate log_mark should fail: %v", err)
+	}
+	if GetWriterInstance("load_zap") != nil || GetWriterInstance("load_std") != nil {
+		t.Fatal("failed load should not register writers")
+	}
+
+	content = strings.Replace(content, `log_mark: "load_zap"
+    backend: "logrus"`, `log_mark: "load_logrus"
+    backend: "logrus"`, 1)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigs(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		CloseWrite("load_zap")
+		CloseWrite("load_std")
+		CloseWrite("load_logrus")
+	}()
+	for _, mark := range []string{"load_zap", "load_std", "load_logrus"} {
+		if GetWriterInstance(mark) == nil {
+			t.Fatalf("%s not registered", mark)
+		}
+	}
+	GetWriterInstance("load_std").InfoW("std infow", Field("i", 1))
+	CloseWrite("load_std")
+	data, err := os.ReadFile(filepath.Join(dir, "std.log"))
+	if err != nil || !strings.Contains(string(data), "std infow") {
+		t.Fatalf("std file content wrong: %v %s", err, data)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cases := []struct {
+		cfg *config.LogConfig
+		err error
+	}{
+		{&config.LogConfig{IsConsole: true, LogLevel: "verbose"}, ErrUnknownLevel},
+		{&config.LogConfig{IsConsole: true, Backend: "glog"}, ErrUnknownBackend},
+		{&config.LogConfig{IsConsole: true, Rotatelog: &config.Rotatelog{SplitDay: 1}, Lumberjack: &config.Lumberjack{}}, ErrSplitConflict},
+		{&config.LogConfig{IsConsole: true, LogName: "app", Rotatelog: &config.Rotatelog{SplitDay: 1}}, ErrNoTimePlaceholder},
+		{&config.LogConfig{IsConsole: true, LogName: "app_$day", Rotatelog: &config.Rotatelog{}}, ErrNoSplitTime},
+		{&config.LogConfig{IsConsole: true, LogName: "app_$day", Rotatelog: &config.Rotatelog{SplitDay: 1, Location: "Mars/Base"}}, ErrUnknownLocation},
+		{&config.LogConfig{IsConsole: true, LogName: "app_%Y%Q", Rotatelog: &config.Rotatelog{SplitDay: 1}}, ErrInvalidTimeFormat},
+		{&config.LogConfig{IsConsole: true, LogName: "app_$day", Rotatelog: &config.Rotatelog{SplitDay: 1, MaxAge: "7days"}}, ErrInvalidDuration},
+		{&config.LogConfig{IsConsole: true, Compression: &config.Compression{Codec: "snappy"}}, ErrUnknownCompressor},
+		{&config.LogConfig{IsConsole: true, Compression: &config.Compression{Level: 42}}, ErrInvalidCompressLevel},
+		{&config.LogConfig{IsConsole: true, Lumberjack: &config.Lumberjack{MaxSize: -1}}, ErrNegativeValue},
+	}
+	for _, c := range cases {
+		err := ValidateConfig(c.cfg)
+		if !errors.Is(err, c.err) {
+			t.Fatalf("want %v got %v", c.err, err)
+		}
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Fatalf("%v is not ConfigError", err)
+		}
+	}
+	if err := ValidateConfig(&config.LogConfig{}); !errors.Is(err, ErrInvalidOutputConfig) || !strings.Contains(err.Error(), "log config output set error: log config output set error") {
+		t.Fatalf("want wrapped ErrInvalidOutputConfig got %v", err)
+	}
+	if err := ValidateConfig(&config.LogConfig{IsConsole: true, LogLevel: "INFO"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadLogConfig(filepath.Join(t.TempDir(), "none.yaml")); !errors.Is(err, ErrReadConfig) {
+		t.Fatalf("want ErrReadConfig got %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewZapWriter(JsonEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	err = w.ApplyConfig(&config.LogConfig{LogDir: filepath.Join(file, "sub"), LogName: "app"})
+	if !errors.Is(err, ErrOpenLogFile) {
+		t.Fatalf("want ErrOpenLogFile got %v", err)
+	}
+}
+
 func TestZapSetLevel(t *testing.T) {
 	c := common.GetBaseLogConfig()
 	writer, err := NewZapWriter(JsonEncodingType)
@@ -52,6 +182,109 @@ func TestZapSetLevel(t *testing.T) {
 	writer.Close()
 }
 
+func TestZapLevelIsolation(t *testing.T) {
+	dir := t.TempDir()
+	newWriter := func(name string) Writer {
+		w, err := NewZapWriter(JsonEncodingType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetConfig(&config.LogConfig{LogDir: dir, LogName: name, ErrLogName: name + "_err", LogLevel: "debug", IsProd: true})
+		return w
+	}
+	access := newWriter("access")
+	audit := newWriter("audit")
+
+	access.SetLevel("error")
+	audit.(*ZapWriter).SetErrLevel("warn")
+	if access.GetLevel() != ErrorLevel || audit.GetLevel() != DebugLevel {
+		t.Fatalf("levels leaked: access=%d audit=%d", access.GetLevel(), audit.GetLevel())
+	}
+	if access.(*ZapWriter).GetErrLevel() != ErrorLevel {
+		t.Fatalf("err level leaked: %d", access.(*ZapWriter).GetErrLevel())
+	}
+
+	access.InfoW("access info")
+	access.WarnW("access warn")
+	audit.InfoW("audit info")
+	audit.WarnW("audit warn")
+	access.Close()
+	audit.Close()
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if content := read("access.log"); content != "" {
+		t.Fatalf("access should drop info and warn: %s", content)
+	}
+	if content := read("audit.log"); !strings.Contains(content, "audit info") {
+		t.Fatalf("audit should keep info: %s", content)
+	}
+	if content := read("audit_err.log"); !strings.Contains(content, "audit warn") {
+		t.Fatalf("audit err file should keep warn: %s", content)
+	}
+	if content := read("access_err.log"); content != "" {
+		t.Fatalf("access err file should be empty: %s", content)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log.yaml")
+	writeConfig := func(content string, at time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	writeConfig(fmt.Sprintf("log_dir: %q\nlog_name: \"old\"\nlog_level: \"debug\"\nis_prod: true\n", dir), now)
+	c := new(config.LogConfig)
+	common.ParserYamlData(path, c)
+	w, err := NewZapWriter(JsonEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetConfig(c)
+	var errs []error
+	watcher, err := WatchConfig(path, w, time.Hour, func(err error) { errs = append(errs, err) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	w.InfoW("before reload")
+	writeConfig(fmt.Sprintf("log_dir: %q\nlog_name: \"new\"\nlog_level: \"warn\"\nis_prod: true\n", dir), now.Add(time.Second))
+	if err = watcher.check(); err != nil {
+		t.Fatal(err)
+	}
+	w.InfoW("after reload info")
+	w.WarnW("after reload warn")
+	writeConfig("log_level: [", now.Add(2*time.Second))
+	if err = watcher.check(); err == nil {
+		t.Fatal("invalid config should be reported")
+	}
+	w.Close()
+
+	if w.GetLevel() != WarnLevel {
+		t.Fatalf("level not reloaded: %d", w.GetLevel())
+	}
+	old, err := os.ReadFile(filepath.Join(dir, "old.log"))
+	if err != nil || !strings.Contains(string(old), "before reload") {
+		t.Fatalf("old file not renamed: %v %s", err, old)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "new.log"))
+	if err != nil || strings.Contains(string(data), "after reload info") || !strings.Contains(string(data), "after reload warn") {
+		t.Fatalf("new file content wrong: %v %s", err, data)
+	}
+}
+
 func TestZapLogger(t *testing.T) {
 	c := common.GetBaseLogConfig()
 	writer, err := NewZapWriter(JsonEncodingType)
@@ -181,6 +414,112 @@ func TestLogger(t *testing.T) { //golang 自带log包
 	ErrorW("go errorw", LogFields{"xxx": 1, "yyyy": 2, "zzz": mgs})
 }
 
+func TestContextLogger(t *testing.T) {
+	type requestIdKey struct{}
+	RegisterContextExtractor("request_id", func(ctx context.Context) []LogField {
+		if id, ok := ctx.Value(requestIdKey{}).(string); ok {
+			return []LogField{Field("request_id", id)}
+		}
+		return nil
+	})
+	defer UnregisterContextExtractor("request_id")
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	ctx := context.WithValue(context.Background(), requestIdKey{}, "req-1")
+	ctx = ContextWithLogFields(ctx, LogFields{"tenant": "t1"})
+	w.InfoCtxW(ctx, "ctx infow", Field("i", 1))
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["request_id"] != "req-1" || entry["tenant"] != "t1" || entry["i"] != float64(1) {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+func TestWithNamed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf).Named("svc").With(Field("module", "order")).Named("db")
+	w.InfoW("with infow", Field("i", 1))
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry[LoggerKey] != "svc.db" || entry["module"] != "order" || entry["i"] != float64(1) {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+
+	// 派生后修改父logger的等级 子logger同样生效
+	buf.Reset()
+	parent := NewWriter(buf)
+	child := parent.With(Field("module", "order"))
+	parent.SetLevel("error")
+	child.Info("hidden")
+	if buf.Len() != 0 || child.GetLevel() != ErrorLevel {
+		t.Fatalf("child level not shared: %d %s", child.GetLevel(), buf.String())
+	}
+
+	// zap的子logger在父logger热加载后使用新的输出
+	dir := t.TempDir()
+	zw, err := NewZapWriter(JsonEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zchild := zw.Named("svc").With(Field("module", "order"))
+	zw.SetConfig(&config.LogConfig{LogDir: dir, LogName: "child", LogLevel: "debug", IsProd: true})
+	zchild.Info("after reload")
+	zw.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "child.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry = make(map[string]interface{})
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("%s: %s", err, data)
+	}
+	if entry["msg"] != "after reload" || entry["logger"] != "svc" || entry["module"] != "order" {
+		t.Fatalf("unexpected zap entry: %v", entry)
+	}
+}
+
+func TestPanicFatal(t *testing.T) {
+	code := -1
+	SetExitFunc(func(c int) { code = c })
+	defer SetExitFunc(nil)
+
+	dir := t.TempDir()
+	w, err := NewZapWriter(JsonEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetConfig(&config.LogConfig{LogDir: dir, LogName: "fatal", LogLevel: "debug", IsProd: true})
+	RegisterWriter("fatal", w)
+	defer CloseWrite("fatal")
+	w.FatalW("zap fatalw", Field("i", 1))
+	if code != 1 {
+		t.Fatalf("exit code %d", code)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "fatal.log")); err != nil {
+		t.Fatalf("temp file not renamed: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	func() {
+		defer func() {
+			if r := recover(); r != "go panicf 1" {
+				t.Fatalf("unexpected panic: %v", r)
+			}
+		}()
+		NewWriter(buf).PanicF("go panicf %d", 1)
+	}()
+	if !bytes.Contains(buf.Bytes(), []byte(LevelPanic)) {
+		t.Fatalf("panic not logged: %s", buf.String())
+	}
+}
+
 func TestNewLoggerManager(t *testing.T) {
 	c := common.GetBaseLogConfig()
 	//w, err := NewZapWriter(c, JsonEncodingType,DefaultSkipOffset)
@@ -330,3 +669,1385 @@ func BenchmarkLogger(b *testing.B) {
 		})
 	})
 }
+
+type gateLogFile struct {
+	gate  chan struct{}
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	exits int
+}
+
+func (g *gateLogFile) Write(p []byte) (int, error) {
+	<-g.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.buf.Write(p)
+}
+
+func (g *gateLogFile) Exit() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.exits++
+	return nil
+}
+
+func (g *gateLogFile) String() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.buf.String()
+}
+
+func TestAsyncLogFile(t *testing.T) {
+	file := &gateLogFile{gate: make(chan struct{})}
+	a := NewAsyncLogFile(file, &config.Async{BufferSize: 3, BatchSize: 1, Policy: AsyncPolicyDropLow})
+	// 第一行被后台协程取走后阻塞在gate上 缓冲里再放满3行
+	a.Write([]byte(`{"level":"info","msg":"first"}` + "\n"))
+	for a.Buffered() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	a.Write([]byte(`{"level":"debug","msg":"d1"}` + "\n"))
+	a.Write([]byte("2023-01-01\twarn\tw1\n"))
+	a.Write([]byte(`time="x" level=debug msg=d2` + "\n"))
+	a.Write([]byte(`{"level":"error","msg":"e1"}` + "\n")) //挤掉d1
+	a.Write([]byte(`{"level":"debug","msg":"d3"}` + "\n")) //没有更低等级 丢弃自己
+	if a.Dropped() != 2 || a.DroppedByLevel(DebugLevel) != 2 {
+		t.Fatalf("dropped %d debug %d", a.Dropped(), a.DroppedByLevel(DebugLevel))
+	}
+	close(file.gate)
+	if err := a.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	got := file.String()
+	for _, want := range []string{"first", "w1", "d2", "e1"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("%s not flushed: %s", want, got)
+		}
+	}
+	if strings.Index(got, "w1") > strings.Index(got, "d2") || strings.Index(got, "d2") > strings.Index(got, "e1") {
+		t.Fatalf("order changed: %s", got)
+	}
+	if strings.Contains(got, "d1") || strings.Contains(got, "d3") || file.exits != 1 {
+		t.Fatalf("unexpected content %s exits %d", got, file.exits)
+	}
+	if _, err := a.Write([]byte("late\n")); !errors.Is(err, ErrAsyncClosed) {
+		t.Fatalf("write after exit: %v", err)
+	}
+
+	file = &gateLogFile{gate: make(chan struct{})}
+	close(file.gate)
+	a = NewAsyncLogFile(file, &config.Async{BufferSize: 2, FlushInterval: 10000})
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(a, "line %d\n", i)
+	}
+	a.Flush()
+	if n := strings.Count(file.String(), "\n"); n != 100 || a.Dropped() != 0 {
+		t.Fatalf("block policy wrote %d dropped %d", n, a.Dropped())
+	}
+	a.Exit()
+
+	dir := t.TempDir()
+	w, err := NewZapWriter(JsonEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.ApplyConfig(&config.LogConfig{LogDir: dir, LogName: "async", Async: &config.Async{Policy: AsyncPolicyDropNewest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.InfoW("async zap", Field("i", 1))
+	w.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "async.log"))
+	if err != nil || !strings.Contains(string(data), "async zap") {
+		t.Fatalf("async file content wrong: %v %s", err, data)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: "metrics", LogLevel: "debug", Lumberjack: &config.Lumberjack{MaxSize: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterWriter("metrics_zap", w); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseWrite("metrics_zap")
+	w.Info("info line")
+	w.Named("child").WarnW("warn line", Field("k", "v"))
+	w.Debug("debug line")
+	file := w.(*ZapWriter).sinks.info.file.(metricsFile).LogFileWrite.(*lumberjack.Logger)
+	if err := file.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := GetMetrics("metrics_zap")
+	if m.Lines(InfoLevel) != 1 || m.Lines(WarnLevel) != 1 || m.Lines(DebugLevel) != 1 {
+		t.Fatalf("lines wrong %+v", m.Snapshot())
+	}
+	if s := m.Snapshot(); s.Bytes <= 0 || s.Rotations != 1 || s.WriteErrors != 0 {
+		t.Fatalf("snapshot wrong %+v", s)
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE xlog_lines_total counter",
+		`xlog_lines_total{logger="metrics_zap",level="warn"} 1`,
+		`xlog_rotations_total{logger="metrics_zap"} 1`,
+		`xlog_async_dropped_total{level="debug"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("%s not in metrics:\n%s", want, body)
+		}
+	}
+
+	PublishExpvar()
+	PublishExpvar()
+	var vars map[string]MetricsSnapshot
+	if err := json.Unmarshal([]byte(expvar.Get("xlog").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["metrics_zap"].Lines[LevelInfo] != 1 {
+		t.Fatalf("expvar wrong %+v", vars)
+	}
+
+	for _, backend := range []string{BackendLogrus, BackendStd} {
+		w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: backend, Backend: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.ErrorF("error %d", 1)
+		w.With(Field("k", 1)).Error("error")
+		if n := metricsOf(w).Lines(ErrorLevel); n != 2 {
+			t.Fatalf("%s error lines %d", backend, n)
+		}
+		w.Close()
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	w := NewConsoleWriter(InfoLevel, JsonEncodingType)
+	if err := RegisterWriter("level_std", w); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseWrite("level_std")
+	handler := LevelHandler()
+	do := func(method, body string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/level?mark=level_std", strings.NewReader(body)))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, body := do(http.MethodGet, ""); code != http.StatusOK || !strings.Contains(body, `"level":"info"`) {
+		t.Fatalf("get %d %s", code, body)
+	}
+	if code, _ := do(http.MethodPut, `{"mark":"level_std","level":"verbose"}`); code != http.StatusBadRequest {
+		t.Fatalf("bad level code %d", code)
+	}
+	if code, _ := do(http.MethodPut, `{"mark":"none","level":"debug"}`); code != http.StatusNotFound {
+		t.Fatalf("unknown mark code %d", code)
+	}
+	if code, _ := do(http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("delete code %d", code)
+	}
+
+	code, body := do(http.MethodPut, `{"mark":"level_std","level":"debug","duration":"100ms"}`)
+	if code != http.StatusOK || !strings.Contains(body, `"revert_level":"info"`) || w.GetLevel() != DebugLevel {
+		t.Fatalf("override %d %s", code, body)
+	}
+	//临时修改期间再次修改 恢复的仍是最初的等级
+	if code, body = do(http.MethodPost, `{"mark":"level_std","level":"warn","duration":"100ms"}`); code != http.StatusOK || !strings.Contains(body, `"revert_level":"info"`) {
+		t.Fatalf("second override %d %s", code, body)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for w.GetLevel() != InfoLevel && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.GetLevel() != InfoLevel {
+		t.Fatalf("level not reverted: %d", w.GetLevel())
+	}
+	if _, body = do(http.MethodGet, ""); strings.Contains(body, "expires_at") {
+		t.Fatalf("override not cleared: %s", body)
+	}
+
+	do(http.MethodPut, `{"mark":"level_std","level":"error","duration":"50ms"}`)
+	do(http.MethodPut, `{"mark":"level_std","level":"warn"}`) //永久修改取消定时
+	time.Sleep(150 * time.Millisecond)
+	if w.GetLevel() != WarnLevel {
+		t.Fatalf("permanent level reverted: %d", w.GetLevel())
+	}
+}
+
+type redactUser struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Phone    string `json:"phone" xlog:"mask=last4"`
+	Card     string `xlog:"redact"`
+	Inner    *redactUser
+}
+
+func TestRedact(t *testing.T) {
+	dir := t.TempDir()
+	redact := &config.Redact{
+		Keys:     []string{"password", "*_token"},
+		Patterns: []string{`1[3-9]\d{9}`},
+	}
+	user := &redactUser{Name: "bob", Password: "pw-secret", Phone: "13800001234", Card: "card-secret",
+		Inner: &redactUser{Name: "alice", Password: "inner-secret"}}
+	for _, backend := range []string{BackendZap, BackendLogrus, BackendStd} {
+		w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: backend, Backend: backend, Redact: redact})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.With(Field("access_token", "token-secret")).InfoW("call 13912345678 done", Field("user", user), Field("Password", "pw2-secret"))
+		w.InfoF("phone %s", "13712345678")
+		w.Close()
+		data, err := os.ReadFile(filepath.Join(dir, backend+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := string(data)
+		for _, secret := range []string{"secret", "13912345678", "13712345678", "13800001234"} {
+			if strings.Contains(content, secret) {
+				t.Fatalf("%s leaked %s: %s", backend, secret, content)
+			}
+		}
+		for _, want := range []string{"*******1234", "bob", "alice", DefaultRedactMask} {
+			if !strings.Contains(content, want) {
+				t.Fatalf("%s missing %s: %s", backend, want, content)
+			}
+		}
+	}
+
+	if err := ValidateConfig(&config.LogConfig{IsConsole: true, Redact: &config.Redact{Patterns: []string{"("}}}); !errors.Is(err, ErrInvalidRedactRule) {
+		t.Fatalf("invalid pattern: %v", err)
+	}
+	//没有配置时结构体标签仍然生效
+	if v := defaultRedactor.Value(user).(map[string]interface{}); v["Card"] != DefaultRedactMask || v["password"] != "pw-secret" {
+		t.Fatalf("default redactor %v", v)
+	}
+	if got := maskString("abcdef", "first2"); got != "ab****" {
+		t.Fatal(got)
+	}
+
+	// 没有规则匹配时原样返回 有匹配时按json的规则展开匿名字段 自定义编码的类型不处理
+	r, _ := NewRedactor(&config.Redact{Keys: []string{"password"}})
+	plain := struct {
+		Err error `json:"err"`
+		At  time.Time
+	}{Err: errors.New("boom"), At: time.Unix(0, 0)}
+	if v := r.Value(plain); !reflect.DeepEqual(v, plain) {
+		t.Fatalf("plain value rewritten: %#v", v)
+	}
+	event := redactEvent{RedactBase: RedactBase{Token: "t", Password: "p"}, At: time.Unix(0, 0)}
+	v, ok := r.Value(event).(map[string]interface{})
+	if !ok || v["password"] != DefaultRedactMask || v["token"] != "t" || v["at"] != event.At || len(v) != 4 {
+		t.Fatalf("event value wrong: %#v", v)
+	}
+	if v := r.Value(redactJSON{Password: "p"}); v != (redactJSON{Password: "p"}) {
+		t.Fatalf("marshaler rewritten: %#v", v)
+	}
+}
+
+type RedactBase struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type redactEvent struct {
+	RedactBase
+	Err  error     `json:"err"`
+	At   time.Time `json:"at"`
+	Note string    `json:"note,omitempty"`
+}
+
+type redactJSON struct {
+	Password string
+}
+
+func (redactJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`"custom"`), nil
+}
+
+// syncBuffer 汇总由定时器协程打印 测试读取时需要加锁
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSampling(t *testing.T) {
+	dir := t.TempDir()
+	sampling := &config.Sampling{Interval: 100, First: 2, Thereafter: 3}
+	for _, backend := range []string{BackendZap, BackendLogrus, BackendStd} {
+		w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: backend, Backend: backend, Sampling: sampling})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			w.WarnW("hot warn", Field("i", i)) //打印第1 2 5 8条
+			w.InfoF("hot info %d", i)          //按format采样
+		}
+		w.Error("error line")
+		time.Sleep(300 * time.Millisecond) //等待周期结束打印汇总
+		w.Close()
+		data, err := os.ReadFile(filepath.Join(dir, backend+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := string(data)
+		if n := strings.Count(content, "hot warn"); n != 4+1 { //汇总里带一次原内容
+			t.Fatalf("%s hot warn %d: %s", backend, n, content)
+		}
+		if strings.Count(content, "suppressed 6 similar messages") != 2 || !strings.Contains(content, "error line") {
+			t.Fatalf("%s summary wrong: %s", backend, content)
+		}
+	}
+
+	buf := new(syncBuffer)
+	w := NewWriter(buf)
+	if err := w.ApplyConfig(&config.LogConfig{IsConsole: true, Sampling: &config.Sampling{Interval: 100, RateLimit: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		w.InfoF("line %d", i)
+	}
+	time.Sleep(300 * time.Millisecond)
+	content := buf.String()
+	if n := strings.Count(content, "line "); n != 5 || !strings.Contains(content, "suppressed 15 messages by rate limit") {
+		t.Fatalf("rate limit wrong %d: %s", n, content)
+	}
+	if err := ValidateConfig(&config.LogConfig{IsConsole: true, Sampling: &config.Sampling{First: -1}}); !errors.Is(err, ErrNegativeValue) {
+		t.Fatalf("negative sampling: %v", err)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: "dedup", Backend: BackendStd, Dedup: &config.Dedup{Window: 200}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		w.WarnW("retry failed", Field("host", "db1"))
+	}
+	w.WarnW("retry failed", Field("host", "db2")) //字段不同 打印之前的汇总
+	w.InfoF("other")
+	w.InfoF("other") //周期结束时打印汇总
+	time.Sleep(400 * time.Millisecond)
+	w.InfoF("other") //新周期重新打印
+	w.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "dedup.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line[strings.IndexByte(line, '{'):]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, entry)
+	}
+	if len(lines) != 6 {
+		t.Fatalf("lines %d: %s", len(lines), data)
+	}
+	if lines[1][RepeatCountKey] != float64(4) || lines[1]["host"] != "db1" || lines[1][FirstSeenKey] == nil || lines[1][LastSeenKey] == nil {
+		t.Fatalf("repeat summary wrong: %v", lines[1])
+	}
+	if lines[2]["host"] != "db2" || lines[2][RepeatCountKey] != nil {
+		t.Fatalf("db2 line wrong: %v", lines[2])
+	}
+	if lines[4][ContentKey] != "other" || lines[4][RepeatCountKey] != float64(1) || lines[5][RepeatCountKey] != nil {
+		t.Fatalf("window summary wrong: %v %v", lines[4], lines[5])
+	}
+	if err := ValidateConfig(&config.LogConfig{IsConsole: true, Dedup: &config.Dedup{Window: -1}}); !errors.Is(err, ErrNegativeValue) {
+		t.Fatalf("negative dedup: %v", err)
+	}
+
+	// zap在core中合并 logrus在写文件的hook中合并 With绑定的字段不同时不算重复
+	for _, backend := range []string{BackendZap, BackendLogrus} {
+		w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: "dedup_" + backend, Backend: backend,
+			LogLevel: "debug", IsProd: true, Dedup: &config.Dedup{Window: 200}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			w.With(Field("host", "db1")).Warn("retry failed")
+		}
+		w.With(Field("host", "db2")).Warn("retry failed")
+		w.Close()
+		data, err := os.ReadFile(filepath.Join(dir, "dedup_"+backend+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 3 || !strings.Contains(lines[1], `"repeat_count":4`) || !strings.Contains(lines[1], "db1") ||
+			!strings.Contains(lines[2], "db2") || strings.Contains(lines[2], RepeatCountKey) {
+			t.Fatalf("%s dedup wrong: %s", backend, data)
+		}
+	}
+}
+
+func TestNetworkLogFile(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	lines := make(chan string, 16)
+	accepted := make(chan net.Conn, 4)
+	serve := func(ln net.Listener) {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}
+	expect := func(want ...string) {
+		for _, line := range want {
+			select {
+			case got := <-lines:
+				if got != line {
+					t.Fatalf("got %q want %q", got, line)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("wait %q timeout", line)
+			}
+		}
+	}
+	go serve(ln)
+
+	spool := filepath.Join(t.TempDir(), "net.spool")
+	f, err := NewNetworkLogFile(&config.Network{Address: addr, MinBackoff: 20, MaxBackoff: 50}, spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Exit()
+	f.Write([]byte("a\n"))
+	expect("a")
+
+	ln.Close() //关闭监听和已建立的连接 之后的日志写到spool
+	(<-accepted).Close()
+	waitFor(t, func() bool { return !f.Connected() })
+	f.Write([]byte("b\n"))
+	f.Write([]byte("c\n"))
+	if data, _ := os.ReadFile(spool); string(data) != "b\nc\n" {
+		t.Fatalf("spool content %q", data)
+	}
+
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(ln)
+	expect("b", "c") //重连后先重放spool
+	f.Write([]byte("d\n"))
+	expect("d")
+	if f.Spooled() != 0 {
+		t.Fatalf("spool not cleared %d", f.Spooled())
+	}
+
+	// 启动时在后台重放上次的spool 重放期间的写入排在spool之后
+	restart := filepath.Join(t.TempDir(), "restart.spool")
+	if err := os.WriteFile(restart, []byte("e\nf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewNetworkLogFile(&config.Network{Address: addr, MinBackoff: 20, MaxBackoff: 50}, restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Exit()
+	r.Write([]byte("g\n"))
+	expect("e", "f", "g")
+	waitFor(t, func() bool { return r.Spooled() == 0 })
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	w, err := NewWriterFromConfig(&config.LogConfig{Backend: BackendStd, Network: &config.Network{Protocol: "udp", Address: pc.LocalAddr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.InfoW("udp line", Field("k", 1))
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil || !strings.Contains(string(buf[:n]), "udp line") {
+		t.Fatalf("udp read %q %v", buf[:n], err)
+	}
+	w.Close()
+
+	// 文件名中的$ti和$rand不影响spool文件名 重启后还能找到上次未重放的日志
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+	dir := t.TempDir()
+	netCfg := &config.Network{Address: down.Addr().String(), Spool: true, MinBackoff: 1000}
+	for i, want := range []int64{0, 2} {
+		file, err := NewNetworkLogWriter(dir, "info_$ti_$rand", netCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := file.(*NetworkLogFile).Spooled(); n != want {
+			t.Fatalf("start %d spooled %d", i, n)
+		}
+		file.Write([]byte("e\n"))
+		file.Exit()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "info"+SpoolSuffix)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cfg := range []*config.LogConfig{
+		{Network: &config.Network{Protocol: "sctp", Address: addr}},
+		{Network: &config.Network{Protocol: "udp", Address: addr, Tls: &config.NetworkTLS{}}},
+		{Network: &config.Network{}},
+		{Network: &config.Network{Address: addr}, Lumberjack: &config.Lumberjack{}},
+	} {
+		if err := ValidateConfig(cfg); err == nil {
+			t.Fatalf("network config should fail: %+v", cfg.Network)
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 150; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("wait condition timeout")
+}
+
+func TestSyslog(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "log.sock")
+	pc, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	w, err := NewWriterFromConfig(&config.LogConfig{Backend: BackendStd, LogLevel: "debug",
+		Syslog: &config.Syslog{Address: sock, Facility: "local0", AppName: "xlog-test", Hostname: "host1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WarnW("disk full", Field("path", `/data"x]`), Field("used", 99))
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := string(buf[:n])
+	if !strings.HasPrefix(frame, "<132>1 ") { //local0*8+warning
+		t.Fatalf("pri wrong: %s", frame)
+	}
+	if !strings.Contains(frame, " host1 xlog-test ") || !strings.HasSuffix(frame, ` [fields@32473 path="/data\"x\]" used="99"] disk full`) {
+		t.Fatalf("frame wrong: %s", frame)
+	}
+	w.Close()
+
+	for _, backend := range []string{BackendZap, BackendLogrus} { //text格式无法从行中解析等级
+		w, err := NewWriterFromConfig(&config.LogConfig{Backend: backend, LogLevel: "debug", Encoding: "text",
+			Syslog: &config.Syslog{Address: sock, Facility: "local0"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Error("text error " + backend)
+		var frame string
+		for !strings.Contains(frame, "text error "+backend) { //zap的堆栈每行单独一帧
+			pc.SetReadDeadline(time.Now().Add(3 * time.Second))
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frame = string(buf[:n])
+		}
+		if !strings.HasPrefix(frame, "<131>1 ") { //local0*8+err
+			t.Fatalf("%s text pri wrong: %s", backend, frame)
+		}
+		w.Close()
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, err := NewSyslogLogFile(&config.Syslog{Protocol: "tcp", Address: ln.Addr().String(), Format: "rfc3164", Hostname: "host1", AppName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Exit()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s.Write([]byte("{\"level\":\"error\",\"content\":\"a\"}\n{\"level\":\"debug\",\"content\":\"b\"}\n"))
+	reader := bufio.NewReader(conn)
+	for _, want := range []string{"<11>", "<15>"} { //user*8+err user*8+debug
+		size, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(size))
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(reader, msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(msg), want) || !strings.Contains(string(msg), " host1 app[") {
+			t.Fatalf("tcp frame wrong: %s", msg)
+		}
+	}
+
+	for _, cfg := range []*config.Syslog{{Format: "rfc1"}, {Facility: "local9"}, {Protocol: "udp", Tls: &config.NetworkTLS{}}} {
+		if err := ValidateConfig(&config.LogConfig{Syslog: cfg}); err == nil {
+			t.Fatalf("syslog config should fail: %+v", cfg)
+		}
+	}
+}
+
+func TestHttpLogFile(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	fails := 2
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fails > 0 { //前两次失败 验证重试
+			fails--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			body = gz
+		}
+		data, _ := io.ReadAll(body)
+		bodies = append(bodies, string(data))
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer es.Close()
+
+	h, err := NewHttpLogFile(&config.Http{Url: es.URL + "/_bulk", Gzip: true, BatchSize: 2, Index: "app", MinBackoff: 10}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte("{\"level\":\"info\",\"content\":\"a\"}\n"))
+	h.Write([]byte("{\"level\":\"error\",\"content\":\"b\"}\n"))
+	h.Flush()                    //关闭时不再重试 先等待重试成功
+	h.Write([]byte("plain c\n")) //不满一批 关闭时发送
+	h.Exit()
+	mu.Lock()
+	if len(bodies) != 2 || bodies[0] != "{\"index\":{\"_index\":\"app\"}}\n{\"level\":\"info\",\"content\":\"a\"}\n{\"index\":{\"_index\":\"app\"}}\n{\"level\":\"error\",\"content\":\"b\"}\n" {
+		t.Fatalf("bulk body wrong: %q", bodies)
+	}
+	if !strings.Contains(bodies[1], `"content":"plain c"`) {
+		t.Fatalf("plain line not wrapped: %q", bodies[1])
+	}
+	mu.Unlock()
+
+	var push map[string][]lokiStream
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer loki.Close()
+	w, err := NewWriterFromConfig(&config.LogConfig{Backend: BackendStd, Http: &config.Http{Url: loki.URL, Format: "loki", Labels: map[string]string{"app": "test"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Info("loki info")
+	w.Error("loki error")
+	w.Close()
+	mu.Lock()
+	if len(push["streams"]) != 2 || push["streams"][0].Stream["level"] != LevelInfo || push["streams"][1].Stream["app"] != "test" ||
+		!strings.Contains(push["streams"][1].Values[0][1], "loki error") {
+		t.Fatalf("loki push wrong: %+v", push)
+	}
+	mu.Unlock()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	dead := filepath.Join(t.TempDir(), "app.dead")
+	h, err = NewHttpLogFile(&config.Http{Url: down.URL, MaxRetries: 2, MinBackoff: 10, FlushInterval: 20}, dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Write([]byte("{\"content\":\"lost\"}\n"))
+	h.Flush()
+	h.Exit()
+	if data, _ := os.ReadFile(dead); string(data) != "{\"content\":\"lost\"}\n" || h.Failed() != 1 {
+		t.Fatalf("dead letter wrong %q %d", data, h.Failed())
+	}
+	h.deadLetter([]httpEntry{{line: []byte("late")}}) //Exit后才失败的批 例如写入时等待队列的
+	if data, _ := os.ReadFile(dead); !strings.HasSuffix(string(data), "\nlate\n") || h.Failed() != 2 {
+		t.Fatalf("late dead letter wrong %q %d", data, h.Failed())
+	}
+
+	for _, cfg := range []*config.Http{{}, {Url: "ftp://x"}, {Url: "http://x", Format: "splunk"}, {Url: "http://x", BatchSize: -1}} {
+		if err := ValidateConfig(&config.LogConfig{Http: cfg}); err == nil {
+			t.Fatalf("http config should fail: %+v", cfg)
+		}
+	}
+}
+
+func TestOtlpExport(t *testing.T) {
+	var mu sync.Mutex
+	var req otlpLogsRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request wrong: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	w, err := NewWriterFromConfig(&config.LogConfig{Backend: BackendStd,
+		Http: &config.Http{Url: srv.URL + "/v1/logs", Format: "otlp", Labels: map[string]string{OtlpServiceNameKey: "checkout"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceId, spanId := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	ctx := ContextWithFields(context.Background(), Field(TraceIdKey, traceId), Field(SpanIdKey, spanId))
+	w.WarnCtxW(ctx, "otlp warn", Field("n", 3), Field("ok", true))
+	w.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(req.ResourceLogs) != 1 || len(req.ResourceLogs[0].ScopeLogs[0].LogRecords) != 1 {
+		t.Fatalf("request wrong: %+v", req)
+	}
+	resource := make(map[string]otlpAnyValue)
+	for _, attr := range req.ResourceLogs[0].Resource.Attributes {
+		resource[attr.Key] = attr.Value
+	}
+	if v := resource[OtlpServiceNameKey].StringValue; v == nil || *v != "checkout" {
+		t.Fatalf("service name wrong: %+v", resource)
+	}
+	if v := resource[OtlpProcessPidKey].IntValue; v == nil || *v != strconv.Itoa(os.Getpid()) || resource[OtlpHostNameKey].StringValue == nil {
+		t.Fatalf("resource wrong: %+v", resource)
+	}
+	record := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	if record.SeverityNumber != 13 || record.SeverityText != "WARN" || *record.Body.StringValue != "otlp warn" {
+		t.Fatalf("record wrong: %+v", record)
+	}
+	if record.TraceId != traceId || record.SpanId != spanId || len(record.Attributes) != 2 ||
+		record.Attributes[0].Key != "n" || *record.Attributes[0].Value.IntValue != "3" || !*record.Attributes[1].Value.BoolValue {
+		t.Fatalf("record ids or attributes wrong: %+v", record)
+	}
+	record = otlpRecord(httpEntry{ts: time.Now(), level: ErrorLevel, line: []byte("ERROR\ttext error")}) //text格式从行中解析不到等级
+	if record.SeverityNumber != 17 || record.SeverityText != "ERROR" || *record.Body.StringValue != "ERROR\ttext error" {
+		t.Fatalf("text record wrong: %+v", record)
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tc, err := ParseTraceparent(header)
+	if err != nil || tc.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.SpanId != "00f067aa0ba902b7" || !tc.Sampled() || tc.Traceparent() != header {
+		t.Fatalf("parse traceparent wrong: %+v %v", tc, err)
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		header + "-extra",
+	} {
+		if _, err := ParseTraceparent(bad); !errors.Is(err, ErrInvalidTraceparent) {
+			t.Fatalf("%q should be invalid", bad)
+		}
+	}
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Fatalf("future version should be accepted: %v", err)
+	}
+	if id := NewTraceId(); len(id) != 32 || !isHexId(id, 16) || len(NewSpanId()) != 16 {
+		t.Fatalf("new id wrong: %s", id)
+	}
+
+	dir := t.TempDir()
+	ctx := ContextWithTraceparent(context.Background(), header)
+	child, _ := TraceFromContext(ctx)
+	if child.TraceId != tc.TraceId || child.ParentSpanId != tc.SpanId || child.SpanId == tc.SpanId {
+		t.Fatalf("child span wrong: %+v", child)
+	}
+	SetTraceFieldKeys("trace.id", "")
+	defer SetTraceFieldKeys("", "")
+	for _, backend := range []string{BackendZap, BackendLogrus, BackendStd} {
+		w, err := NewWriterFromConfig(&config.LogConfig{LogDir: dir, LogName: backend, Backend: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.InfoCtx(ctx, "traced")
+		w.Info("untraced")
+		w.Close()
+		data, err := os.ReadFile(filepath.Join(dir, backend+".log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[0], `"trace.id":"`+tc.TraceId+`"`) || !strings.Contains(lines[0], `"span_id":"`+child.SpanId+`"`) ||
+			strings.Contains(lines[1], tc.TraceId) {
+			t.Fatalf("%s trace fields wrong: %s", backend, data)
+		}
+	}
+
+	var got TraceContext
+	handler := TraceHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = TraceFromContext(r.Context())
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !got.IsValid() || got.ParentSpanId != "" || rec.Header().Get(TraceparentHeader) != got.Traceparent() {
+		t.Fatalf("new trace wrong: %+v %s", got, rec.Header().Get(TraceparentHeader))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(TraceparentHeader, header)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got.TraceId != tc.TraceId || got.ParentSpanId != tc.SpanId {
+		t.Fatalf("propagated trace wrong: %+v", got)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	buf := new(syncBuffer)
+	w := NewWriter(buf)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/missing", func(rw http.ResponseWriter, r *http.Request) {
+		http.NotFound(rw, r)
+	})
+	mux.HandleFunc("/panic", func(rw http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/late-panic", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusAccepted)
+		panic("late boom")
+	})
+	handler := NewAccessLogHandler(w, mux, &AccessLogOptions{SkipPaths: []string{"/health*"}, TrustProxy: true})
+	serve := func(h http.Handler, method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("User-Agent", "xlog-test")
+		req.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve(handler, http.MethodGet, "/ok?a=1")
+	serve(handler, http.MethodGet, "/health/live")
+	serve(handler, http.MethodPost, "/missing")
+	if rec := serve(handler, http.MethodGet, "/panic"); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("panic status %d", rec.Code)
+	}
+	if rec := serve(handler, http.MethodGet, "/late-panic"); rec.Code != http.StatusAccepted {
+		t.Fatalf("late panic status %d", rec.Code)
+	}
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 6 {
+		t.Fatalf("entries %d: %s", len(entries), buf.String())
+	}
+	ok := entries[0]
+	if ok[LevelKey] != LevelInfo || ok["status"] != float64(200) || ok["bytes"] != float64(5) || ok["query"] != "a=1" ||
+		ok["remote_ip"] != "10.0.0.1" || ok["user_agent"] != "xlog-test" || ok["latency_ms"] == nil {
+		t.Fatalf("ok entry wrong: %v", ok)
+	}
+	if entries[1][LevelKey] != LevelWarn || entries[1]["status"] != float64(404) {
+		t.Fatalf("404 entry wrong: %v", entries[1])
+	}
+	if entries[2][LevelKey] != LevelError || entries[2]["panic"] != "boom" || !strings.Contains(entries[2]["stack"].(string), "TestAccessLog") {
+		t.Fatalf("panic entry wrong: %v", entries[2])
+	}
+	if entries[3][LevelKey] != LevelError || entries[3]["status"] != float64(500) || entries[3]["panicked"] != true {
+		t.Fatalf("panic access entry wrong: %v", entries[3])
+	}
+	//已写入状态码后panic 记录实际返回的状态码
+	if entries[5][LevelKey] != LevelError || entries[5]["status"] != float64(http.StatusAccepted) || entries[5]["panicked"] != true {
+		t.Fatalf("late panic access entry wrong: %v", entries[5])
+	}
+
+	buf = new(syncBuffer)
+	w = NewWriter(buf)
+	serve(NewAccessLogHandler(w, mux, &AccessLogOptions{Format: AccessFormatCombined}), http.MethodGet, "/ok")
+	line := buf.String()
+	if !regexp.MustCompile(`"content":"192\.0\.2\.1 - - \[[^\]]+\] \\"GET /ok HTTP/1\.1\\" 200 5 \\"\\" \\"xlog-test\\""`).MatchString(line) {
+		t.Fatalf("combined line wrong: %s", line)
+	}
+}
+
+func TestRotateAlign(t *testing.T) {
+	start := time.Date(2024, 3, 5, 10, 37, 20, 0, time.UTC)
+	open := func(cfg *config.Rotatelog, name string) (*rotatelogs.RotateLogs, clockwork.FakeClock) {
+		fc := clockwork.NewFakeClockAt(start)
+		w, err := newRotateLogWriter(t.TempDir(), name, cfg, rotatelogs.WithClock(fc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rl := w.(*rotatelogs.RotateLogs)
+		if _, err := rl.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+		return rl, fc
+	}
+
+	//按分钟切分 在下一个整分切分而不是启动后的一分钟
+	rl, fc := open(&config.Rotatelog{SplitMinute: 1}, "app_$minute")
+	if !strings.Contains(rl.CurrentFileName(), "_10_37") {
+		t.Fatalf("first file %s", rl.CurrentFileName())
+	}
+	fc.BlockUntil(1)
+	fc.Advance(40 * time.Second)
+	waitFor(t, func() bool { return strings.Contains(rl.CurrentFileName(), "_10_38") })
+	fc.BlockUntil(1)
+	fc.Advance(time.Minute)
+	waitFor(t, func() bool { return strings.Contains(rl.CurrentFileName(), "_10_39") })
+	rl.Exit()
+
+	//按小时切分并偏移30分钟 11:30切分
+	rl, fc = open(&config.Rotatelog{SplitHour: 1, SplitOffset: 30}, "app_$hour")
+	first := rl.CurrentFileName()
+	fc.BlockUntil(1)
+	fc.Advance(52 * time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if rl.CurrentFileName() != first {
+		t.Fatalf("rotated before offset: %s", rl.CurrentFileName())
+	}
+	fc.Advance(time.Minute)
+	waitFor(t, func() bool { return strings.Contains(rl.CurrentFileName(), "_11") })
+	rl.Exit()
+}
+
+func TestRotateRetention(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+	prepare := func(names ...string) string {
+		dir := t.TempDir()
+		for _, name := range names {
+			//修改时间都是现在 只有按文件名中的时间才会被清理
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Truncate(filepath.Join(dir, name), 1<<20); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	files := func(dir string) []string {
+		matches, _ := filepath.Glob(filepath.Join(dir, "app_*"))
+		for i := range matches {
+			matches[i] = filepath.Base(matches[i])
+		}
+		return matches
+	}
+	old := []string{"app_2024_03_10_08.log", "app_2024_3_10_9.log", "app_2024_03_10_10.log", "app_2024_03_10_11.log"}
+
+	//启动时按max_age清理 不需要写日志
+	dir := prepare(old...)
+	w, err := newRotateLogWriter(dir, "app_$hour", &config.Rotatelog{SplitHour: 1, MaxAge: "3h"},
+		rotatelogs.WithClock(clockwork.NewFakeClockAt(now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(files(dir)) == 2 })
+	if got := files(dir); got[0] != "app_2024_03_10_10.log" || got[1] != "app_2024_03_10_11.log" {
+		t.Fatalf("max_age kept %v", got)
+	}
+	w.Exit()
+
+	//按总大小从最旧的开始清理
+	dir = prepare(old...)
+	w, err = newRotateLogWriter(dir, "app_$hour", &config.Rotatelog{SplitHour: 1, MaxTotalSize: 1},
+		rotatelogs.WithClock(clockwork.NewFakeClockAt(now)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(files(dir)) == 2 })
+	if got := files(dir); got[0] != "app_2024_03_10_11.log" || got[1] != "app_2024_03_10_12.temp" {
+		t.Fatalf("max_total_size kept %v", got)
+	}
+	w.Exit()
+}
+
+func TestRotateCompress(t *testing.T) {
+	dir := t.TempDir()
+	fc := clockwork.NewFakeClockAt(time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC))
+	metrics := new(WriterMetrics)
+	w, err := newRotateLogWriter(dir, "app_$minute", &config.Rotatelog{SplitMinute: 1, MaxSave: 2, Compress: true},
+		rotatelogs.WithClock(fc), rotatelogs.WithHandler(metrics.rotateHandler()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Exit()
+	files := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(dir, "app_*"))
+		for i := range matches {
+			matches[i] = filepath.Base(matches[i])
+		}
+		return matches
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write([]byte(fmt.Sprintf("line %d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+		fc.BlockUntil(1)
+		fc.Advance(time.Minute)
+		waitFor(t, func() bool { return atomic.LoadInt64(&metrics.compressed) == int64(i+1) })
+	}
+
+	//max_save为2 压缩后的文件和正在写的文件各算一份
+	waitFor(t, func() bool { return len(files()) == 2 })
+	got := files()
+	if got[0] != "app_2024_03_10_12_32.log.gz" || got[1] != "app_2024_03_10_12_33.temp" {
+		t.Fatalf("files %v", got)
+	}
+	f, err := os.Open(filepath.Join(dir, got[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(gz)
+	if string(data) != "line 2\n" {
+		t.Fatalf("compressed content %q", data)
+	}
+}
+
+type nopCompressor struct{}
+
+func (nopCompressor) Suffix() string { return ".nop" }
+
+func (nopCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestCompressor(t *testing.T) {
+	//deflate压缩切分后的文件
+	dir := t.TempDir()
+	fc := clockwork.NewFakeClockAt(time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC))
+	c, err := newCompressor(&config.Compression{Codec: CompressDeflate, Level: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := new(WriterMetrics)
+	w, err := newRotateLogWriter(dir, "app_$minute", &config.Rotatelog{SplitMinute: 1, Compress: true},
+		rotatelogs.WithClock(fc), rotatelogs.WithCompressor(c), rotatelogs.WithHandler(metrics.rotateHandler()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("deflate line\n")); err != nil {
+		t.Fatal(err)
+	}
+	fc.BlockUntil(1)
+	fc.Advance(time.Minute)
+	waitFor(t, func() bool { return atomic.LoadInt64(&metrics.compressed) == 1 })
+	w.Exit()
+	f, err := os.Open(filepath.Join(dir, "app_2024_03_10_12_30.log.deflate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(flate.NewReader(f))
+	f.Close()
+	if string(data) != "deflate line\n" {
+		t.Fatalf("deflate content %q", data)
+	}
+
+	//注册的压缩方式可以在配置中使用 清理旧文件时能识别它的后缀 原文件和压缩文件只算一份
+	RegisterCompressor("nop", ".nop", func(level int) (Compressor, error) { return nopCompressor{}, nil })
+	cfg := &config.LogConfig{IsConsole: true, Compression: &config.Compression{Codec: "NOP"}}
+	if err := ValidateConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	dir = t.TempDir()
+	for _, name := range []string{"app_2024_03_10_09.log.nop", "app_2024_03_10_10.log", "app_2024_03_10_10.log.nop", "app_2024_03_10_11.log.nop"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err = newRotateLogWriter(dir, "app_$hour", &config.Rotatelog{SplitHour: 1, MaxSave: 2, Compress: true},
+		rotatelogs.WithClock(clockwork.NewFakeClockAt(time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Exit()
+	waitFor(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "app_2024_03_10_09.log.nop"))
+		return os.IsNotExist(err)
+	})
+	for _, name := range []string{"app_2024_03_10_10.log", "app_2024_03_10_10.log.nop", "app_2024_03_10_11.log.nop"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s should be kept: %v", name, err)
+		}
+	}
+}
+
+func TestPanicRecover(t *testing.T) {
+	dir := t.TempDir()
+	zw, err := NewZapWriter(JsonEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writers := map[string]Writer{
+		"std":    NewWriter(io.Discard),
+		"zap":    zw, //zap打印panic时会调用Sync
+		"logrus": NewLogrusWriter(),
+	}
+	for name, w := range writers {
+		w.SetConfig(&config.LogConfig{LogDir: dir, LogName: "recover_" + name, LogLevel: "debug", IsProd: true})
+		mark := "recover_" + name
+		RegisterWriter(mark, w)
+		func() {
+			defer func() {
+				if r := recover(); r != "go panic" {
+					t.Fatalf("%s unexpected panic: %v", name, r)
+				}
+			}()
+			w.Panic("go panic")
+		}()
+		// panic后文件已经改成.log 但没有关闭 recover后还可以继续打印
+		path := filepath.Join(dir, "recover_"+name+".log")
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%s temp file not renamed: %s", name, err)
+		}
+		w.Info("after recover")
+		CloseWrite(mark)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("go panic")) || !bytes.Contains(data, []byte("after recover")) {
+			t.Fatalf("%s lines lost after recover: %s", name, data)
+		}
+	}
+}
+
+func TestReloadFixedName(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(io.Discard)
+	w.SetConfig(&config.LogConfig{LogDir: dir, LogName: "fixed", LogLevel: "debug", IsProd: true})
+	w.Info("first segment")
+	// 重新打开同名文件 旧文件改成fixed.log 新文件改名时不能覆盖它
+	w.SetConfig(&config.LogConfig{LogDir: dir, LogName: "fixed", ErrLogName: "fixed_err", LogLevel: "debug", IsProd: true})
+	w.Info("second segment")
+	w.Close()
+
+	for name, line := range map[string]string{"fixed.log": "first segment", "fixed_1.log": "second segment"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte(line)) {
+			t.Fatalf("%s: %s", name, data)
+		}
+	}
+}
+
+func TestReloadOpenFail(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(io.Discard)
+	w.SetConfig(&config.LogConfig{LogDir: dir, LogName: "keep", LogLevel: "debug", IsProd: true})
+	if err := os.Mkdir(filepath.Join(dir, "bad"+common.LogTemp), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 新文件打开失败时保留旧文件 写入不能丢失
+	if err := w.ApplyConfig(&config.LogConfig{LogDir: dir, LogName: "bad", LogLevel: "debug", IsProd: true}); err == nil {
+		t.Fatal("open dir should fail")
+	}
+	w.Info("still here")
+	w.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "keep.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("still here")) {
+		t.Fatalf("keep.log: %s", data)
+	}
+}
+
+type levelLogFile struct {
+	mu     sync.Mutex
+	levels []int
+}
+
+func (l *levelLogFile) Write(p []byte) (int, error) {
+	return l.WriteLevel(-1, p)
+}
+
+func (l *levelLogFile) WriteLevel(level int, p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.levels = append(l.levels, level)
+	return len(p), nil
+}
+
+func (l *levelLogFile) Exit() error {
+	return nil
+}
+
+func TestWriteLevel(t *testing.T) {
+	dir := t.TempDir()
+	zw, err := NewZapWriter(TextEncodingType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writers := map[string]Writer{
+		"std":    NewWriter(io.Discard),
+		"zap":    zw,
+		"logrus": NewLogrusWriter(),
+	}
+	for name, w := range writers {
+		if err := w.ApplyConfig(&config.LogConfig{LogDir: dir, LogName: "level_" + name, LogLevel: "debug"}); err != nil {
+			t.Fatal(err)
+		}
+		var sinks *logSinks
+		switch v := w.(type) {
+		case *concreteWriter:
+			sinks = v.sinks
+		case *ZapWriter:
+			sinks = v.sinks
+		case *LogrusWriter:
+			sinks = v.sinks
+		}
+		file := new(levelLogFile)
+		sinks.info.swap(func() (LogFileWrite, error) { return file, nil }, nil)
+		// 内容中的等级文本不影响写入时的等级
+		w.Warn(`level=debug "level":"debug"`)
+		w.Error(`level=debug "level":"debug"`)
+		if len(file.levels) != 2 || file.levels[0] != WarnLevel || file.levels[1] != ErrorLevel {
+			t.Fatalf("%s levels %v", name, file.levels)
+		}
+		w.Close()
+	}
+
+	// 异步写按传入的等级缓冲 写入底层文件时带上等级
+	file := new(levelLogFile)
+	a := NewAsyncLogFile(file, nil)
+	a.WriteLevel(ErrorLevel, []byte(`{"level":"debug"}`+"\n"))
+	a.Exit()
+	if len(file.levels) != 1 || file.levels[0] != ErrorLevel {
+		t.Fatalf("async levels %v", file.levels)
+	}
+}
+
+func TestLumberjackEvents(t *testing.T) {
+	dir := t.TempDir()
+	file, err := NewLumberjackLogWriter(dir, "events", &config.Lumberjack{MaxSize: 1, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := file.(*lumberjack.Logger)
+	var (
+		mu      sync.Mutex
+		rotated []string
+	)
+	handler := func(e lumberjack.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == lumberjack.FileRotated {
+			rotated = append(rotated, e.Filename)
+		}
+	}
+	l.SetHandler(handler)
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(l, "line %d\n", i)
+		if err := l.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+		l.SetHandler(handler) //清理协程调用handler时可以同时设置
+	}
+	l.Exit()
+
+	// 事件中是切分后的文件名 不是正在写的文件名
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"events.log", "events_1.log", "events_2.log"}
+	if len(rotated) != len(want) {
+		t.Fatalf("rotated %v", rotated)
+	}
+	for i, name := range want {
+		if rotated[i] != filepath.Join(dir, name) {
+			t.Fatalf("rotated %v", rotated)
+		}
+	}
+}
+
+func TestLogrusCloseOwnSinks(t *testing.T) {
+	dir := t.TempDir()
+	a, b := NewLogrusWriter(), NewLogrusWriter()
+	a.SetConfig(&config.LogConfig{LogDir: dir, LogName: "logrus_a", LogLevel: "debug", IsProd: true})
+	b.SetConfig(&config.LogConfig{LogDir: dir, LogName: "logrus_b", LogLevel: "debug", IsProd: true})
+	a.Close() //不能关闭其他logrus writer的文件
+	b.Info("b still open")
+	b.Close()
+	data, err := os.ReadFile(filepath.Join(dir, "logrus_b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("b still open")) {
+		t.Fatalf("logrus_b.log: %s", data)
+	}
+}
@@ -0,0 +1,71 @@
+package xlog
+
+import (
+	"os"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	exitMu   sync.RWMutex
+	exitFunc = os.Exit
+)
+
+// SetExitFunc 设置fatal打印后的退出函数 默认os.Exit 测试时可替换
+func SetExitFunc(fn func(code int)) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	if fn == nil {
+		fn = os.Exit
+	}
+	exitFunc = fn
+}
+
+func getExitFunc() func(code int) {
+	exitMu.RLock()
+	defer exitMu.RUnlock()
+	return exitFunc
+}
+
+// logFlusher 刷新日志但不关闭文件的日志对象
+type logFlusher interface {
+	flush()
+}
+
+// flushAll 刷新默认日志对象和所有注册的日志对象 并把.temp文件改成.log
+// closing为true时关闭文件 用于退出前 否则文件保持打开
+func flushAll(closing bool) {
+	if w := writer.GetWriter(); w != nil {
+		flushWriter(w, closing)
+	}
+	loggerMgr.flushAll(closing)
+}
+
+func flushWriter(w Writer, closing bool) {
+	if closing {
+		w.Close()
+	} else if f, ok := w.(logFlusher); ok {
+		f.flush()
+	}
+}
+
+// panicAfterFlush 吞掉后端自身的panic 刷新所有日志后统一以msg重新panic
+// 需要defer调用 非defer调用时只刷新并panic 不关闭文件 panic被recover后还可以继续打印
+func panicAfterFlush(msg string) {
+	recover()
+	flushAll(false)
+	panic(msg)
+}
+
+func exitAfterFlush() {
+	flushAll(true)
+	getExitFunc()(1)
+}
+
+// fatalHook 替换zap默认的os.Exit 保证退出前刷新所有日志
+type fatalHook struct{}
+
+func (fatalHook) OnWrite(*zapcore.CheckedEntry, []zapcore.Field) {
+	exitAfterFlush()
+}
@@ -0,0 +1,530 @@
+package xlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crx666/xlog/common"
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	HttpFormatElasticsearch = "elasticsearch"
+	HttpFormatLoki          = "loki"
+	HttpFormatOtlp          = "otlp" //OTLP/HTTP json编码 url为.../v1/logs
+
+	DefaultHttpIndex         = "xlog"
+	DefaultHttpBatchSize     = 500
+	DefaultHttpBatchBytes    = 1024 //单位:KB
+	DefaultHttpFlushInterval = time.Second
+	DefaultHttpTimeout       = 10 * time.Second
+	DefaultHttpMaxRetries    = 3
+	DefaultHttpMinBackoff    = 500 * time.Millisecond
+	DefaultHttpMaxBackoff    = 10 * time.Second
+
+	DeadLetterSuffix = ".dead"
+
+	httpMaxQueue = 16 //等待发送的最大批数 满了之后写入阻塞
+)
+
+var (
+	ErrNoHttpUrl         = errors.New("http url is empty or invalid")
+	ErrUnknownHttpFormat = errors.New("unknown http format")
+	ErrHttpConflict      = errors.New("http can not be set with syslog network rotatelog or lumberjack")
+	ErrHttpClosed        = errors.New("http log file closed")
+)
+
+type httpEntry struct {
+	ts    time.Time
+	level int
+	line  []byte
+}
+
+// HttpLogFile 把json日志按批发送到elasticsearch的_bulk loki的push或OTLP/HTTP的logs接口
+// 按行数 大小和时间分批 由后台协程发送 失败时按退避间隔重试
+// 重试用完 或服务端返回不可重试的4xx时写到dead letter文件 没有配置时丢弃
+type HttpLogFile struct {
+	client     *http.Client
+	url        string
+	format     string
+	index      string
+	labels     map[string]string
+	resource   []otlpKeyValue //otlp的resource属性
+	headers    map[string]string
+	batchSize  int
+	batchBytes int
+	interval   time.Duration
+	gzip       bool
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond //队列有空位 队列发送完 关闭时广播
+	batch   []httpEntry
+	size    int
+	queue   [][]httpEntry
+	sending bool
+	closed  bool
+	wake    chan struct{}
+	stop    chan struct{} //关闭时不再等待重试
+	done    chan struct{}
+
+	deadMu     sync.Mutex
+	deadName   string
+	dead       *os.File
+	deadClosed bool //Exit已关闭dead 之后的dead letter每次单独打开文件追加
+	failed     int64
+}
+
+var _ LogFileWrite = (*HttpLogFile)(nil)
+
+// NewHttpLogWriter 根据配置创建http输出 开启dead_letter时文件为dir下的file.dead
+func NewHttpLogWriter(dir, file string, cfg *config.Http) (LogFileWrite, error) {
+	var deadName string
+	if cfg.DeadLetter {
+		if err := makeLogDir(dir); err != nil {
+			return nil, err
+		}
+		deadName = filepath.Join(common.ReplaceDir(dir), strings.TrimSuffix(common.ReplaceName(file), common.LogFormal)+DeadLetterSuffix)
+	}
+	return NewHttpLogFile(cfg, deadName)
+}
+
+// NewHttpLogFile deadName为空时不写dead letter文件
+func NewHttpLogFile(cfg *config.Http, deadName string) (*HttpLogFile, error) {
+	if err := validateHttp(cfg); err != nil {
+		return nil, err
+	}
+	h := &HttpLogFile{
+		url:        cfg.Url,
+		format:     strings.ToLower(cfg.Format),
+		index:      cfg.Index,
+		labels:     cfg.Labels,
+		headers:    cfg.Headers,
+		batchSize:  cfg.BatchSize,
+		batchBytes: cfg.BatchBytes * 1024,
+		interval:   millisOr(cfg.FlushInterval, DefaultHttpFlushInterval),
+		gzip:       cfg.Gzip,
+		maxRetries: cfg.MaxRetries,
+		minBackoff: millisOr(cfg.MinBackoff, DefaultHttpMinBackoff),
+		maxBackoff: millisOr(cfg.MaxBackoff, DefaultHttpMaxBackoff),
+		client:     &http.Client{Timeout: millisOr(cfg.Timeout, DefaultHttpTimeout)},
+		deadName:   deadName,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if h.format == "" {
+		h.format = HttpFormatElasticsearch
+	}
+	if h.format == HttpFormatOtlp {
+		h.resource = otlpResource(h.labels)
+	}
+	if h.index == "" {
+		h.index = DefaultHttpIndex
+	}
+	if h.batchSize <= 0 {
+		h.batchSize = DefaultHttpBatchSize
+	}
+	if h.batchBytes <= 0 {
+		h.batchBytes = DefaultHttpBatchBytes * 1024
+	}
+	if h.maxRetries <= 0 {
+		h.maxRetries = DefaultHttpMaxRetries
+	}
+	if h.maxBackoff < h.minBackoff {
+		h.maxBackoff = h.minBackoff
+	}
+	h.cond = sync.NewCond(&h.mu)
+	go h.run()
+	return h, nil
+}
+
+func validateHttp(cfg *config.Http) error {
+	if u, err := url.Parse(cfg.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return newConfigError("http.url", cfg.Url, ErrNoHttpUrl)
+	}
+	switch strings.ToLower(cfg.Format) {
+	case "", HttpFormatElasticsearch, HttpFormatLoki, HttpFormatOtlp:
+	default:
+		return newConfigError("http.format", cfg.Format, ErrUnknownHttpFormat)
+	}
+	return checkNegative(
+		intField{"http.batch_size", cfg.BatchSize},
+		intField{"http.batch_bytes", cfg.BatchBytes},
+		intField{"http.flush_interval", cfg.FlushInterval},
+		intField{"http.timeout", cfg.Timeout},
+		intField{"http.max_retries", cfg.MaxRetries},
+		intField{"http.min_backoff", cfg.MinBackoff},
+		intField{"http.max_backoff", cfg.MaxBackoff},
+	)
+}
+
+// Write p中每一行作为一条日志 满一批时放入发送队列 队列满时阻塞
+// Write 没有传入等级时从每行内容中识别
+func (h *HttpLogFile) Write(p []byte) (int, error) {
+	return h.write(-1, p)
+}
+
+// WriteLevel p中的每行都使用传入的等级
+func (h *HttpLogFile) WriteLevel(level int, p []byte) (int, error) {
+	return h.write(level, p)
+}
+
+// write level小于0时从内容中识别
+func (h *HttpLogFile) write(level int, p []byte) (int, error) {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return 0, ErrHttpClosed
+	}
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		data := make([]byte, len(line)) //调用方会复用p 需要复制
+		copy(data, line)
+		lv := level
+		if lv < 0 {
+			lv = ParseLineLevel(data)
+		}
+		if h.closed { //enqueueLocked等待期间已关闭 剩余的行不会再发送
+			h.deadLetter([]httpEntry{{ts: now, level: lv, line: data}})
+			continue
+		}
+		h.batch = append(h.batch, httpEntry{ts: now, level: lv, line: data})
+		h.size += len(data)
+		if len(h.batch) >= h.batchSize || h.size >= h.batchBytes {
+			h.enqueueLocked()
+		}
+	}
+	return len(p), nil
+}
+
+func (h *HttpLogFile) enqueueLocked() {
+	for len(h.queue) >= httpMaxQueue && !h.closed {
+		h.notify()
+		h.cond.Wait()
+	}
+	if h.closed { //等待期间已关闭 后台协程可能已退出
+		h.deadLetter(h.batch)
+	} else {
+		h.queue = append(h.queue, h.batch)
+		h.notify()
+	}
+	h.batch, h.size = nil, 0
+}
+
+// Flush 等待已写入的日志发送完成
+func (h *HttpLogFile) Flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.batch) > 0 && !h.closed {
+		h.enqueueLocked()
+	}
+	for (len(h.queue) > 0 || h.sending) && !h.closed {
+		h.notify()
+		h.cond.Wait()
+	}
+}
+
+// Exit 发送完剩余的日志后退出 发送失败时不再重试直接写dead letter
+func (h *HttpLogFile) Exit() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	if len(h.batch) > 0 {
+		h.queue = append(h.queue, h.batch)
+		h.batch, h.size = nil, 0
+	}
+	h.closed = true
+	close(h.stop)
+	h.cond.Broadcast()
+	h.mu.Unlock()
+	h.notify()
+	<-h.done
+
+	h.deadMu.Lock()
+	defer h.deadMu.Unlock()
+	h.deadClosed = true
+	if h.dead != nil {
+		return h.dead.Close()
+	}
+	return nil
+}
+
+// Failed 写到dead letter或丢弃的行数
+func (h *HttpLogFile) Failed() int64 {
+	return atomic.LoadInt64(&h.failed)
+}
+
+func (h *HttpLogFile) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (h *HttpLogFile) run() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		partial := false
+		select {
+		case <-h.wake:
+		case <-ticker.C:
+			partial = true
+		}
+		if !h.flushQueue(partial) {
+			return
+		}
+	}
+}
+
+// flushQueue 发送队列中的所有批 partial时不满一批的也发送 已关闭且发送完时返回false
+func (h *HttpLogFile) flushQueue(partial bool) bool {
+	for {
+		h.mu.Lock()
+		if partial && len(h.batch) > 0 {
+			h.queue = append(h.queue, h.batch)
+			h.batch, h.size = nil, 0
+		}
+		partial = false
+		h.sending = false
+		if len(h.queue) == 0 {
+			closed := h.closed
+			h.cond.Broadcast()
+			h.mu.Unlock()
+			return !closed
+		}
+		batch := h.queue[0]
+		h.queue[0] = nil
+		h.queue = h.queue[1:]
+		h.sending = true
+		h.cond.Broadcast()
+		h.mu.Unlock()
+		h.send(batch)
+	}
+}
+
+// send 发送一批 可重试的错误按退避间隔重试 关闭后不再重试
+func (h *HttpLogFile) send(batch []httpEntry) {
+	body, contentType, err := h.encode(batch)
+	if err != nil {
+		log.Println("xlog http encode error", err.Error())
+		h.deadLetter(batch)
+		return
+	}
+	backoff := h.minBackoff
+retry:
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		retryable, err = h.post(body, contentType, batch)
+		if err == nil {
+			return
+		}
+		if !retryable || attempt >= h.maxRetries {
+			break
+		}
+		select {
+		case <-h.stop:
+			break retry
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > h.maxBackoff {
+			backoff = h.maxBackoff
+		}
+	}
+	log.Println("xlog http send error", err.Error())
+	h.deadLetter(batch)
+}
+
+// post 返回错误是否可以重试 elasticsearch部分失败时失败的行写dead letter 不重试
+func (h *HttpLogFile) post(body []byte, contentType string, batch []httpEntry) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if h.format == HttpFormatElasticsearch {
+			h.deadLetterBulkErrors(data, batch)
+		}
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("http status %d: %s", resp.StatusCode, data)
+	default:
+		return false, fmt.Errorf("http status %d: %s", resp.StatusCode, data)
+	}
+}
+
+// bulkResponse elasticsearch _bulk的返回 items和请求中的行一一对应
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+	} `json:"items"`
+}
+
+func (h *HttpLogFile) deadLetterBulkErrors(data []byte, batch []httpEntry) {
+	var resp bulkResponse
+	if json.Unmarshal(data, &resp) != nil || !resp.Errors {
+		return
+	}
+	var failed []httpEntry
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 300 && i < len(batch) {
+				failed = append(failed, batch[i])
+			}
+		}
+	}
+	h.deadLetter(failed)
+}
+
+func (h *HttpLogFile) encode(batch []httpEntry) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var gz *gzip.Writer
+	if h.gzip {
+		gz = gzip.NewWriter(&buf)
+		out = gz
+	}
+	contentType := "application/x-ndjson"
+	switch h.format {
+	case HttpFormatLoki:
+		contentType = "application/json"
+		if err := json.NewEncoder(out).Encode(h.lokiPush(batch)); err != nil {
+			return nil, "", err
+		}
+	case HttpFormatOtlp:
+		contentType = "application/json"
+		if err := json.NewEncoder(out).Encode(h.otlpExport(batch)); err != nil {
+			return nil, "", err
+		}
+	default:
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": h.index}})
+		for _, entry := range batch {
+			out.Write(action)
+			out.Write([]byte{'\n'})
+			out.Write(httpJsonLine(entry))
+			out.Write([]byte{'\n'})
+		}
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, "", err
+		}
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiPush 按等级分成不同的stream
+func (h *HttpLogFile) lokiPush(batch []httpEntry) map[string][]lokiStream {
+	values := make(map[int][][2]string)
+	for _, entry := range batch {
+		values[entry.level] = append(values[entry.level], [2]string{strconv.FormatInt(entry.ts.UnixNano(), 10), string(httpJsonLine(entry))})
+	}
+	levels := make([]int, 0, len(values))
+	for level := range values {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	streams := make([]lokiStream, 0, len(levels))
+	for _, level := range levels {
+		labels := make(map[string]string, len(h.labels)+1)
+		for k, v := range h.labels {
+			labels[k] = v
+		}
+		labels[LevelKey] = levelName(level)
+		streams = append(streams, lokiStream{Stream: labels, Values: values[level]})
+	}
+	return map[string][]lokiStream{"streams": streams}
+}
+
+// httpJsonLine 去掉json前的前缀 不是json时包装成json
+func httpJsonLine(entry httpEntry) []byte {
+	if i := bytes.IndexByte(entry.line, '{'); i >= 0 && json.Valid(entry.line[i:]) {
+		return entry.line[i:]
+	}
+	data, _ := json.Marshal(map[string]string{
+		TimestampKey: entry.ts.Format(TimeFormat),
+		LevelKey:     levelName(entry.level),
+		ContentKey:   string(entry.line),
+	})
+	return data
+}
+
+// deadLetter 每行一条写到dead letter文件
+func (h *HttpLogFile) deadLetter(batch []httpEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	atomic.AddInt64(&h.failed, int64(len(batch)))
+	if h.deadName == "" {
+		return
+	}
+	h.deadMu.Lock()
+	defer h.deadMu.Unlock()
+	file := h.dead
+	if file == nil || h.deadClosed {
+		var err error
+		file, err = os.OpenFile(h.deadName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Println("xlog open dead letter error", err.Error())
+			return
+		}
+		if h.deadClosed { //Exit之后写入的 不保留文件句柄
+			defer file.Close()
+		} else {
+			h.dead = file
+		}
+	}
+	var buf bytes.Buffer
+	for _, entry := range batch {
+		buf.Write(entry.line)
+		buf.WriteByte('\n')
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		log.Println("xlog write dead letter error", err.Error())
+	}
+}
@@ -0,0 +1,187 @@
+package xlog
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownLogMark = errors.New("unknown log mark")
+
+// LoggerLevel 注册日志对象的当前等级 有临时修改时带上到期时间和到期后恢复的等级
+type LoggerLevel struct {
+	Mark        string     `json:"mark"`
+	Level       string     `json:"level"`
+	RevertLevel string     `json:"revert_level,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type levelOverride struct {
+	revert  string
+	expires time.Time
+	timer   *time.Timer
+}
+
+var levelOverrides = struct {
+	sync.Mutex
+	items map[string]*levelOverride
+}{items: make(map[string]*levelOverride)}
+
+// SetWriterLevel 修改mark对应日志对象的等级 d>0时为临时修改 到期后恢复修改前的等级
+// 临时修改期间再次修改会取消之前的定时 临时修改恢复的仍是最初的等级
+func SetWriterLevel(mark, level string, d time.Duration) error {
+	level = strings.ToLower(level)
+	if _, ok := LogLevel[level]; !ok {
+		return newConfigError("level", level, ErrUnknownLevel)
+	}
+	w := loggerMgr.getWriter(mark)
+	if w == nil {
+		return ErrUnknownLogMark
+	}
+
+	levelOverrides.Lock()
+	defer levelOverrides.Unlock()
+	old := levelOverrides.items[mark]
+	if old != nil {
+		old.timer.Stop()
+		delete(levelOverrides.items, mark)
+	}
+	if d > 0 {
+		revert := levelName(w.GetLevel())
+		if old != nil {
+			revert = old.revert
+		}
+		o := &levelOverride{revert: revert, expires: time.Now().Add(d)}
+		o.timer = time.AfterFunc(d, func() {
+			revertWriterLevel(mark, o)
+		})
+		levelOverrides.items[mark] = o
+	}
+	w.SetLevel(level)
+	return nil
+}
+
+// revertWriterLevel 临时修改到期 只有o仍是当前的临时修改时才恢复
+func revertWriterLevel(mark string, o *levelOverride) {
+	levelOverrides.Lock()
+	defer levelOverrides.Unlock()
+	if levelOverrides.items[mark] != o {
+		return
+	}
+	delete(levelOverrides.items, mark)
+	if w := loggerMgr.getWriter(mark); w != nil {
+		w.SetLevel(o.revert)
+	}
+}
+
+// GetWriterLevels 返回所有注册日志对象的等级 按mark排序
+func GetWriterLevels() []LoggerLevel {
+	loggerMgr.RLock()
+	levels := make([]LoggerLevel, 0, len(loggerMgr.LoggerInfo))
+	for mark, w := range loggerMgr.LoggerInfo {
+		levels = append(levels, LoggerLevel{Mark: mark, Level: levelName(w.GetLevel())})
+	}
+	loggerMgr.RUnlock()
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].Mark < levels[j].Mark
+	})
+
+	levelOverrides.Lock()
+	defer levelOverrides.Unlock()
+	for i := range levels {
+		if o, ok := levelOverrides.items[levels[i].Mark]; ok {
+			expires := o.expires
+			levels[i].RevertLevel = o.revert
+			levels[i].ExpiresAt = &expires
+		}
+	}
+	return levels
+}
+
+func levelName(level int) string {
+	if level < DebugLevel || level > FatalLevel {
+		return LevelDebug
+	}
+	return levelNames[level]
+}
+
+// levelRequest 修改等级的请求 duration为空时永久修改 如"10m"
+type levelRequest struct {
+	Mark     string `json:"mark"`
+	Level    string `json:"level"`
+	Duration string `json:"duration"`
+}
+
+// LevelHandler 查看和修改注册日志对象等级的http.Handler
+// GET 返回所有日志对象的等级 带?mark=xx时只返回一个
+// PUT/POST {"mark":"app","level":"debug","duration":"10m"} 修改等级 duration为空时永久修改
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			levels := GetWriterLevels()
+			mark := r.URL.Query().Get("mark")
+			if mark == "" {
+				writeLevelJson(w, http.StatusOK, levels)
+				return
+			}
+			for _, level := range levels {
+				if level.Mark == mark {
+					writeLevelJson(w, http.StatusOK, level)
+					return
+				}
+			}
+			writeLevelError(w, http.StatusNotFound, ErrUnknownLogMark)
+		case http.MethodPut, http.MethodPost:
+			var req levelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeLevelError(w, http.StatusBadRequest, err)
+				return
+			}
+			var d time.Duration
+			if req.Duration != "" {
+				var err error
+				if d, err = time.ParseDuration(req.Duration); err != nil {
+					writeLevelError(w, http.StatusBadRequest, err)
+					return
+				}
+				if d <= 0 {
+					writeLevelError(w, http.StatusBadRequest, newConfigError("duration", req.Duration, ErrNegativeValue))
+					return
+				}
+			}
+			if err := SetWriterLevel(req.Mark, req.Level, d); err != nil {
+				status := http.StatusBadRequest
+				if errors.Is(err, ErrUnknownLogMark) {
+					status = http.StatusNotFound
+				}
+				writeLevelError(w, status, err)
+				return
+			}
+			for _, level := range GetWriterLevels() {
+				if level.Mark == req.Mark {
+					writeLevelJson(w, http.StatusOK, level)
+					return
+				}
+			}
+			writeLevelError(w, http.StatusNotFound, ErrUnknownLogMark)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			writeLevelError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+}
+
+func writeLevelJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeLevelError(w http.ResponseWriter, status int, err error) {
+	writeLevelJson(w, status, map[string]string{"error": err.Error()})
+}
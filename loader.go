@@ -0,0 +1,117 @@
+package xlog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/crx666/xlog/common"
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	BackendZap    = "zap"
+	BackendLogrus = "logrus"
+	BackendStd    = "std"
+
+	EncodingJson = "json"
+	EncodingText = "text"
+)
+
+// NewWriterFromConfig 按backend和encoding创建writer并应用配置
+func NewWriterFromConfig(cfg *config.LogConfig) (Writer, error) {
+	err := ValidateConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	encode, _ := parseEncoding(cfg.Encoding)
+	var w Writer
+	switch strings.ToLower(cfg.Backend) {
+	case "", BackendZap:
+		w, err = NewZapWriter(encode)
+		if err != nil {
+			return nil, err
+		}
+	case BackendLogrus:
+		w = NewLogrusWriter(func(logger *logrus.Logger) {
+			if encode == JsonEncodingType {
+				logger.SetFormatter(JsonFormatter)
+			} else {
+				logger.SetFormatter(TextFormatter)
+			}
+		})
+	case BackendStd:
+		w = NewConsoleWriter(DebugLevel, encode)
+	}
+	if err = w.ApplyConfig(cfg); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func parseEncoding(encoding string) (int, error) {
+	switch strings.ToLower(encoding) {
+	case "", EncodingJson:
+		return JsonEncodingType, nil
+	case EncodingText:
+		return TextEncodingType, nil
+	default:
+		return 0, ErrUnknownEncoding
+	}
+}
+
+// LoadConfigs 读取多日志配置文件 每个配置创建一个writer并按log_mark注册
+func LoadConfigs(path string) error {
+	c := new(config.RepeateConfig)
+	if err := common.ReadYamlData(path, c); err != nil {
+		return newConfigError("path", path, fmt.Errorf("%w: %s", ErrReadConfig, err.Error()))
+	}
+	return SetupConfigs(c)
+}
+
+// SetupConfigs 先检查log_mark 再创建writer 任何一个失败都会关闭已创建的writer 不留下部分注册
+func SetupConfigs(c *config.RepeateConfig) error {
+	marks := make(map[string]bool, len(c.Configs))
+	for i, cfg := range c.Configs {
+		if cfg == nil {
+			return fmt.Errorf("config %d is empty", i)
+		}
+		if cfg.LogMark == "" {
+			return fmt.Errorf("config %d log_mark is empty", i)
+		}
+		if marks[cfg.LogMark] || GetWriterInstance(cfg.LogMark) != nil {
+			return fmt.Errorf("config %d log_mark %q: %w", i, cfg.LogMark, ErrRepeatedLogMark)
+		}
+		marks[cfg.LogMark] = true
+	}
+	if c.Default != "" && !marks[c.Default] {
+		return fmt.Errorf("default log_mark %q not found", c.Default)
+	}
+
+	writers := make(map[string]Writer, len(c.Configs))
+	rollback := func() {
+		for mark, w := range writers {
+			loggerMgr.remove(mark, w)
+			w.Close()
+		}
+	}
+	for _, cfg := range c.Configs {
+		w, err := NewWriterFromConfig(cfg)
+		if err != nil {
+			rollback()
+			return fmt.Errorf("log_mark %q: %w", cfg.LogMark, err)
+		}
+		if err = RegisterWriter(cfg.LogMark, w); err != nil {
+			w.Close()
+			rollback()
+			return fmt.Errorf("log_mark %q: %w", cfg.LogMark, err)
+		}
+		writers[cfg.LogMark] = w
+	}
+	if c.Default != "" {
+		ReplaceWriter(writers[c.Default])
+	}
+	return nil
+}
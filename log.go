@@ -1,6 +1,7 @@
 package xlog
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -18,6 +19,11 @@ func SetWriter(w Writer) {
 	writer.SetWriter(w)
 }
 
+// ReplaceWriter 替换默认日志对象 SetWriter只在未设置时生效
+func ReplaceWriter(w Writer) Writer {
+	return writer.ReplaceWriter(w)
+}
+
 func GetWriter() Writer {
 	w := writer.GetWriter()
 	if w == nil {
@@ -48,6 +54,16 @@ func GetLevel() int {
 	return DebugLevel
 }
 
+// With 返回绑定了字段的子日志对象
+func With(fields ...LogFields) Writer {
+	return GetWriter().With(Fields(fields...)...)
+}
+
+// Named 返回带logger名字的子日志对象
+func Named(name string) Writer {
+	return GetWriter().Named(name)
+}
+
 func Debug(v ...interface{}) {
 	GetWriter().Debug(v...)
 }
@@ -96,6 +112,78 @@ func ErrorW(format string, v ...LogFields) {
 	GetWriter().ErrorW(format, Fields(v...)...)
 }
 
+func Panic(v ...interface{}) {
+	GetWriter().Panic(v...)
+}
+
+func PanicF(format string, fields ...interface{}) {
+	GetWriter().PanicF(format, fields...)
+}
+
+func PanicW(format string, v ...LogFields) {
+	GetWriter().PanicW(format, Fields(v...)...)
+}
+
+func Fatal(v ...interface{}) {
+	GetWriter().Fatal(v...)
+}
+
+func FatalF(format string, fields ...interface{}) {
+	GetWriter().FatalF(format, fields...)
+}
+
+func FatalW(format string, v ...LogFields) {
+	GetWriter().FatalW(format, Fields(v...)...)
+}
+
+func DebugCtx(ctx context.Context, v ...interface{}) {
+	GetWriter().DebugCtx(ctx, v...)
+}
+
+func DebugCtxF(ctx context.Context, format string, fields ...interface{}) {
+	GetWriter().DebugCtxF(ctx, format, fields...)
+}
+
+func DebugCtxW(ctx context.Context, format string, v ...LogFields) {
+	GetWriter().DebugCtxW(ctx, format, Fields(v...)...)
+}
+
+func InfoCtx(ctx context.Context, v ...interface{}) {
+	GetWriter().InfoCtx(ctx, v...)
+}
+
+func InfoCtxF(ctx context.Context, format string, fields ...interface{}) {
+	GetWriter().InfoCtxF(ctx, format, fields...)
+}
+
+func InfoCtxW(ctx context.Context, format string, v ...LogFields) {
+	GetWriter().InfoCtxW(ctx, format, Fields(v...)...)
+}
+
+func WarnCtx(ctx context.Context, v ...interface{}) {
+	GetWriter().WarnCtx(ctx, v...)
+}
+
+func WarnCtxF(ctx context.Context, format string, fields ...interface{}) {
+	GetWriter().WarnCtxF(ctx, format, fields...)
+}
+
+func WarnCtxW(ctx context.Context, format string, v ...LogFields) {
+	GetWriter().WarnCtxW(ctx, format, Fields(v...)...)
+}
+
+func ErrorCtx(ctx context.Context, v ...interface{}) {
+	GetWriter().ErrorCtx(ctx, v...)
+}
+
+func ErrorCtxF(ctx context.Context, format string, fields ...interface{}) {
+	GetWriter().ErrorCtxF(ctx, format, fields...)
+}
+
+func ErrorCtxW(ctx context.Context, format string, v ...LogFields) {
+	GetWriter().ErrorCtxW(ctx, format, Fields(v...)...)
+}
+
 type LogField struct {
 	Key   string
 	Value interface{}
@@ -8,6 +8,8 @@ import (
 
 var loggerMgr = NewLoggerManager()
 
+var ErrRepeatedLogMark = errors.New("repeated logger name!")
+
 type LoggerManager struct {
 	sync.RWMutex
 	LoggerInfo map[string]Writer
@@ -26,7 +28,7 @@ func (l *LoggerManager) addWriter(mark string, w Writer) error {
 		l.LoggerInfo[mark] = w
 		return nil
 	}
-	return errors.New("repeated logger name!")
+	return ErrRepeatedLogMark
 }
 
 func (l *LoggerManager) getWriter(mark string) Writer {
@@ -35,6 +37,15 @@ func (l *LoggerManager) getWriter(mark string) Writer {
 	return l.LoggerInfo[mark]
 }
 
+// remove 只删除mark对应的是w时的注册 不关闭
+func (l *LoggerManager) remove(mark string, w Writer) {
+	l.Lock()
+	defer l.Unlock()
+	if old, ok := l.LoggerInfo[mark]; ok && old == w {
+		delete(l.LoggerInfo, mark)
+	}
+}
+
 func (l *LoggerManager) close(mark string) {
 	l.Lock()
 	defer l.Unlock()
@@ -53,8 +64,17 @@ func (l *LoggerManager) closeAll() {
 	l.LoggerInfo = make(map[string]Writer)
 }
 
-func RegisterWriter(mark string, w Writer) {
-	loggerMgr.addWriter(mark, w)
+func (l *LoggerManager) flushAll(closing bool) {
+	l.RLock()
+	defer l.RUnlock()
+	for _, w := range l.LoggerInfo {
+		flushWriter(w, closing)
+	}
+}
+
+// RegisterWriter 注册日志对象 mark重复时返回ErrRepeatedLogMark
+func RegisterWriter(mark string, w Writer) error {
+	return loggerMgr.addWriter(mark, w)
 }
 
 func GetWriterInstance(mark string) Writer {
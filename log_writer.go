@@ -1,14 +1,17 @@
 package xlog
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/crx666/xlog/common"
 	"github.com/crx666/xlog/config"
 )
 
@@ -30,56 +33,174 @@ func newLogWriter(logger *log.Logger) logWriter {
 type concreteWriter struct {
 	infoLog     io.Writer
 	errorLog    io.Writer
-	level       int
+	level       *int32 //原子读写 运行时可修改 With和Named派生的子logger共享
 	encode      int
 	stackOffset int
+	name        string
+	fields      []LogField
+	sinks       *logSinks
+	metrics     *WriterMetrics
+	redact      *redaction
+	sampler     *sampler
+	dedup       *deduper
 }
 
 func NewWriter(w io.Writer) Writer {
 	lw := newLogWriter(log.New(w, "", Flags))
-	return &concreteWriter{
-		infoLog:  lw,
-		errorLog: lw,
-		level:    DebugLevel,
-		encode:   JsonEncodingType,
-	}
+	return newConcreteWriter(lw, lw, DebugLevel, JsonEncodingType)
 }
 
 func NewConsoleWriter(lv int, encode int) Writer {
 	outLog := newLogWriter(log.New(os.Stderr, "", Flags))
 	errLog := newLogWriter(log.New(os.Stderr, "", Flags))
-	return &concreteWriter{
-		infoLog:  outLog,
-		errorLog: errLog,
-		level:    lv,
+	return newConcreteWriter(outLog, errLog, lv, encode)
+}
+
+func newConcreteWriter(infoLog, errorLog io.Writer, lv int, encode int) *concreteWriter {
+	level := int32(lv)
+	w := &concreteWriter{
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		level:    &level,
 		encode:   encode,
+		metrics:  newWriterMetrics(),
+		redact:   newRedaction(),
+	}
+	w.sampler = newSampler(func(level int) bool {
+		return w.checkLevel(levelName(level))
+	}, w.logSummary)
+	w.dedup = newDeduper()
+	return w
+}
+
+// logSummary 打印采样汇总 不经过采样
+func (w *concreteWriter) logSummary(level int, msg string, fields ...LogField) {
+	out := w.infoLog
+	if level >= ErrorLevel {
+		out = w.errorLog
+	}
+	w.output(out, levelName(level), msg, fields...)
+}
+
+func (w *concreteWriter) clone() *concreteWriter {
+	c := *w
+	c.fields = make([]LogField, len(w.fields))
+	copy(c.fields, w.fields)
+	return &c
+}
+
+func (w *concreteWriter) With(fields ...LogField) Writer {
+	c := w.clone()
+	c.fields = append(c.fields, fields...)
+	return c
+}
+
+func (w *concreteWriter) Named(name string) Writer {
+	c := w.clone()
+	c.name = joinLoggerName(w.name, name)
+	return c
+}
+
+func (w *concreteWriter) boundFields(fields []LogField) []LogField {
+	if w.name == "" && len(w.fields) <= 0 {
+		return fields
+	}
+	values := make([]LogField, 0, len(w.fields)+len(fields)+1)
+	if w.name != "" {
+		values = append(values, LogField{Key: LoggerKey, Value: w.name})
 	}
+	values = append(values, w.fields...)
+	values = append(values, fields...)
+	return values
+}
+
+func joinLoggerName(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	if name == "" {
+		return parent
+	}
+	return parent + "." + name
+}
+
+// Metrics 返回打印统计
+func (w *concreteWriter) Metrics() *WriterMetrics {
+	return w.metrics
 }
 
 func (w *concreteWriter) Close() {
+	w.dedup.flush()
+	if w.sinks != nil {
+		w.sinks.exit()
+	}
+}
 
+func (w *concreteWriter) flush() {
+	w.dedup.flush()
+	if w.sinks != nil {
+		w.sinks.flush()
+	}
 }
+
 func (w *concreteWriter) SetStackOffset(stackOffset int) {
 	w.stackOffset = stackOffset
 }
 
 func (w *concreteWriter) SetConfig(config *config.LogConfig) {
+	if config == nil {
+		return
+	}
+	err := common.LogConfigCheck(config)
+	if err != nil {
+		panic(err)
+	}
+	err = w.applyConfig(config)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ApplyConfig 同SetConfig 先完整检查配置 出错时返回错误不panic
+func (w *concreteWriter) ApplyConfig(config *config.LogConfig) error {
+	if config == nil {
+		return nil
+	}
+	err := ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	return w.applyConfig(config)
+}
 
+func (w *concreteWriter) applyConfig(config *config.LogConfig) error {
+	w.SetLevel(config.LogLevel)
+	if err := w.redact.apply(config.Redact); err != nil {
+		return err
+	}
+	w.sampler.apply(config.Sampling)
+	w.dedup.apply(config.Dedup)
+	if w.sinks == nil { //原有输出作为控制台输出 由is_console控制
+		w.sinks = newLogSinks(w.metrics)
+		w.infoLog = w.sinks.fileWriter(w.infoLog, w.sinks.info)
+		w.errorLog = w.sinks.fileWriter(w.errorLog, w.sinks.errWriter())
+	}
+	return w.sinks.apply(config)
 }
 
 func (w *concreteWriter) SetLevel(level string) {
-	if lv, ok := LogLevel[level]; ok {
-		w.level = lv
+	if lv, ok := LogLevel[strings.ToLower(level)]; ok {
+		atomic.StoreInt32(w.level, int32(lv))
 	}
 }
 
 func (w *concreteWriter) GetLevel() int {
-	return w.level
+	return int(atomic.LoadInt32(w.level))
 }
 
 func (w *concreteWriter) checkLevel(levle string) bool {
 	if lv, ok := LogLevel[levle]; ok {
-		if w.level > lv {
+		if w.GetLevel() > lv {
 			return false
 		}
 		return true
@@ -89,54 +210,206 @@ func (w *concreteWriter) checkLevel(levle string) bool {
 }
 
 func (w *concreteWriter) Error(v ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
 	w.output(w.errorLog, LevelError, fmt.Sprint(v...))
 }
 
 func (w *concreteWriter) ErrorF(format string, fields ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
 	w.output(w.errorLog, LevelError, fmt.Sprintf(format, fields...))
 }
 
 func (w *concreteWriter) ErrorW(format string, fields ...LogField) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
 	w.output(w.errorLog, LevelError, format, fields...)
 }
 
 func (w *concreteWriter) Info(v ...interface{}) {
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
 	w.output(w.infoLog, LevelInfo, fmt.Sprint(v...))
 }
 
 func (w *concreteWriter) InfoF(format string, fields ...interface{}) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
 	w.output(w.infoLog, LevelInfo, fmt.Sprintf(format, fields...))
 }
 
 func (w *concreteWriter) InfoW(format string, fields ...LogField) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
 	w.output(w.infoLog, LevelInfo, format, fields...)
 }
 
 func (w *concreteWriter) Debug(v ...interface{}) {
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
 	w.output(w.infoLog, LevelDebug, fmt.Sprint(v...))
 }
 
 func (w *concreteWriter) DebugF(format string, fields ...interface{}) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
 	w.output(w.infoLog, LevelDebug, fmt.Sprintf(format, fields...))
 }
 
 func (w *concreteWriter) DebugW(format string, fields ...LogField) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
 	w.output(w.infoLog, LevelDebug, format, fields...)
 }
 
 func (w *concreteWriter) Warn(v ...interface{}) {
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
 	w.output(w.infoLog, LevelWarn, fmt.Sprint(v...))
 }
 
 func (w *concreteWriter) WarnF(format string, fields ...interface{}) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
 	w.output(w.infoLog, LevelWarn, fmt.Sprintf(format, fields...))
 
 }
 
 func (w *concreteWriter) WarnW(format string, fields ...LogField) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
 	w.output(w.infoLog, LevelWarn, format, fields...)
 }
 
+func (w *concreteWriter) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	w.output(w.errorLog, LevelPanic, msg)
+	panicAfterFlush(msg)
+}
+
+func (w *concreteWriter) PanicF(format string, fields ...interface{}) {
+	msg := fmt.Sprintf(format, fields...)
+	w.output(w.errorLog, LevelPanic, msg)
+	panicAfterFlush(msg)
+}
+
+func (w *concreteWriter) PanicW(format string, fields ...LogField) {
+	w.output(w.errorLog, LevelPanic, format, fields...)
+	panicAfterFlush(format)
+}
+
+func (w *concreteWriter) Fatal(v ...interface{}) {
+	w.output(w.errorLog, LevelFatal, fmt.Sprint(v...))
+	exitAfterFlush()
+}
+
+func (w *concreteWriter) FatalF(format string, fields ...interface{}) {
+	w.output(w.errorLog, LevelFatal, fmt.Sprintf(format, fields...))
+	exitAfterFlush()
+}
+
+func (w *concreteWriter) FatalW(format string, fields ...LogField) {
+	w.output(w.errorLog, LevelFatal, format, fields...)
+	exitAfterFlush()
+}
+
+func (w *concreteWriter) ErrorCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.output(w.errorLog, LevelError, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) ErrorCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.output(w.errorLog, LevelError, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) ErrorCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.output(w.errorLog, LevelError, format, withContextFields(ctx, fields...)...)
+}
+
+func (w *concreteWriter) InfoCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.output(w.infoLog, LevelInfo, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) InfoCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.output(w.infoLog, LevelInfo, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) InfoCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.output(w.infoLog, LevelInfo, format, withContextFields(ctx, fields...)...)
+}
+
+func (w *concreteWriter) DebugCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.output(w.infoLog, LevelDebug, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) DebugCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.output(w.infoLog, LevelDebug, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) DebugCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.output(w.infoLog, LevelDebug, format, withContextFields(ctx, fields...)...)
+}
+
+func (w *concreteWriter) WarnCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.output(w.infoLog, LevelWarn, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) WarnCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.output(w.infoLog, LevelWarn, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *concreteWriter) WarnCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.output(w.infoLog, LevelWarn, format, withContextFields(ctx, fields...)...)
+}
+
 func (w *concreteWriter) SetEncoding(t int) {
 	if t != TextEncodingType && t != JsonEncodingType {
 		panic("unknow encoding type")
@@ -145,9 +418,29 @@ func (w *concreteWriter) SetEncoding(t int) {
 }
 
 func (w *concreteWriter) output(writer io.Writer, level string, val interface{}, fields ...LogField) {
-	if level != LevelError && !w.checkLevel(level) {
+	if LogLevel[level] < ErrorLevel && !w.checkLevel(level) {
+		return
+	}
+	redactor := w.redact.get()
+	if msg, ok := val.(string); ok {
+		val = redactor.Message(msg)
+	}
+	fields = redactor.Fields(w.boundFields(fields))
+	if !w.dedup.enabled() { //没有开启去重时不计算key
+		w.write(writer, level, val, fields...)
 		return
 	}
+	w.dedup.log(dedupKey(level, val, fields), func(extra ...LogField) {
+		w.write(writer, level, val, append(fields[:len(fields):len(fields)], extra...)...)
+	})
+}
+
+// write 编码后写入 fields已经过脱敏
+func (w *concreteWriter) write(writer io.Writer, level string, val interface{}, fields ...LogField) {
+	w.metrics.addLine(LogLevel[level])
+	if writer != nil {
+		writer = leveledWriter{w: writer, level: LogLevel[level]}
+	}
 	switch w.encode {
 	case TextEncodingType:
 		writePlainAny(writer, level, val, buildFields(fields...)...)
@@ -2,6 +2,7 @@ package xlog
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/rifflock/lfshook"
@@ -151,7 +152,17 @@ func (p *pureFieldsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 
 type LogrusWriter struct {
 	logger      *logrus.Logger
+	entry       *logrus.Entry //绑定字段后的entry 所有打印都走entry
+	name        string
 	stackOffset int //默认输出为0
+	sinks       *logSinks
+	formatter   logrus.Formatter //用户设置的格式 文件输出使用
+	out         io.Writer        //用户设置的控制台输出
+	hooked      bool
+	metrics     *WriterMetrics
+	redact      *redaction
+	sampler     *sampler
+	dedup       *deduper
 }
 
 func NewLogrusWriter(opts ...func(logger *logrus.Logger)) Writer {
@@ -162,8 +173,17 @@ func NewLogrusWriter(opts ...func(logger *logrus.Logger)) Writer {
 	}
 	w := &LogrusWriter{
 		logger:      logger,
+		entry:       logrus.NewEntry(logger),
 		stackOffset: HookSkip,
+		metrics:     newWriterMetrics(),
+		redact:      newRedaction(),
+		dedup:       newDeduper(),
 	}
+	logger.AddHook(redactHook{w.redact}) //需要在写文件的hook之前执行
+	logger.AddHook(metricsHook{w.metrics})
+	w.sampler = newSampler(func(level int) bool {
+		return logger.IsLevelEnabled(toLogrusLevel(level))
+	}, w.logSummary)
 	return w
 }
 
@@ -179,85 +199,118 @@ func (w *LogrusWriter) SetConfig(config *config.LogConfig) {
 	if err != nil {
 		panic(err)
 	}
+	err = w.applyConfig(config)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ApplyConfig 同SetConfig 先完整检查配置 出错时返回错误不panic
+func (w *LogrusWriter) ApplyConfig(config *config.LogConfig) error {
+	if config == nil {
+		return nil
+	}
+	err := ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	return w.applyConfig(config)
+}
+
+func (w *LogrusWriter) applyConfig(config *config.LogConfig) error {
 	lv, err := logrus.ParseLevel(config.LogLevel)
 	if err != nil {
 		lv = logrus.DebugLevel
 	}
 	w.logger.SetLevel(lv)
-	formatter := w.logger.Formatter
-	if !config.IsConsole {
+	if err = w.redact.apply(config.Redact); err != nil {
+		return err
+	}
+	w.sampler.apply(config.Sampling)
+	w.dedup.apply(config.Dedup)
+	if w.sinks == nil {
+		w.sinks = newLogSinks(w.metrics)
+		w.formatter = w.logger.Formatter
+		w.out = w.logger.Out
+	}
+	if config.IsConsole {
+		w.logger.SetOutput(w.out)
+		w.logger.SetFormatter(w.formatter)
+	} else {
 		w.logger.SetOutput(io.Discard)
 		w.logger.SetFormatter(TextFormatter) //不输出控制台 改成text格式 减少json内存分配
 	}
-	if config.IsCall {
-		w.logger.SetReportCaller(true)
-	}
+	w.logger.SetReportCaller(config.IsCall)
 
-	if config.LogDir != "" && config.LogName != "" {
-		var info, warn LogFileWrite
-		if config.Rotatelog != nil {
-			info = GetRotateLogWriter(config.LogDir, config.LogName, config.Rotatelog)
-			if config.ErrLogName != "" {
-				warn = GetRotateLogWriter(config.LogDir, config.ErrLogName, config.Rotatelog)
-			}
-		} else if config.Lumberjack != nil {
-			info = GetLumberjackLogWriter(config.LogDir, config.LogName, config.Lumberjack)
-			if config.ErrLogName != "" {
-				warn = GetLumberjackLogWriter(config.LogDir, config.ErrLogName, config.Lumberjack)
-			}
-		} else {
-			info, err = NewNormalLogFile(config.LogDir, config.LogName)
-			if err != nil {
-				panic(err)
-			}
-			if config.ErrLogName != "" {
-				warn, err = NewNormalLogFile(config.LogDir, config.ErrLogName)
-				if err != nil {
-					panic(err)
-				}
+	err = w.sinks.apply(config)
+	if err != nil {
+		return err
+	}
+	if w.hooked || !w.sinks.info.enabled() {
+		return nil
+	}
+	w.hooked = true
+	formatter := w.formatter
+	if _, ok := formatter.(*SimpleFormatter); ok {
+		formatter = NewSimpleFormatter(Skip + w.stackOffset)
+	}
+	info, warn := w.sinks.info, w.sinks.errWriter()
+	hook := lfshook.NewHook(lfshook.WriterMap{
+		logrus.DebugLevel: leveledWriter{w: info, level: DebugLevel}, // 为不同级别设置不同的输出目的
+		logrus.InfoLevel:  leveledWriter{w: info, level: InfoLevel},
+		logrus.WarnLevel:  leveledWriter{w: info, level: WarnLevel},
+		logrus.ErrorLevel: leveledWriter{w: warn, level: ErrorLevel},
+		logrus.FatalLevel: leveledWriter{w: warn, level: FatalLevel},
+		logrus.PanicLevel: leveledWriter{w: warn, level: PanicLevel},
+	}, formatter)
+	w.logger.AddHook(dedupHook{Hook: hook, dedup: w.dedup})
+	sinks, dedup := w.sinks, w.dedup
+	logrus.RegisterExitHandler(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("logrus.RegisterExitHandler error", r)
 			}
+		}()
+		dedup.flush()
+		err := sinks.exit()
+		if err != nil {
+			panic(err)
 		}
+	})
+	return nil
+}
 
-		if warn == nil {
-			warn = info
-		}
+func (w *LogrusWriter) With(fields ...LogField) Writer {
+	c := *w
+	c.entry = w.entry.WithFields(toLogrusFields(fields...))
+	return &c
+}
 
-		if info != nil {
-			if _, ok := formatter.(*SimpleFormatter); ok {
-				formatter = NewSimpleFormatter(Skip + w.stackOffset)
-			}
-			hook := lfshook.NewHook(lfshook.WriterMap{
-				logrus.DebugLevel: info, // 为不同级别设置不同的输出目的
-				logrus.InfoLevel:  info,
-				logrus.WarnLevel:  info,
-				logrus.ErrorLevel: warn,
-				logrus.FatalLevel: warn,
-				logrus.PanicLevel: warn,
-			}, formatter)
-			w.logger.AddHook(hook)
-			logrus.RegisterExitHandler(func() {
-				defer func() {
-					if r := recover(); r != nil {
-						log.Println("logrus.RegisterExitHandler error", r)
-					}
-				}()
-				err := info.Exit()
-				if err != nil {
-					panic(err)
-				}
-				if config.ErrLogName != "" {
-					err = warn.Exit()
-					if err != nil {
-						panic(err)
-					}
-				}
-			})
-		}
-	}
+func (w *LogrusWriter) Named(name string) Writer {
+	c := *w
+	c.name = joinLoggerName(w.name, name)
+	c.entry = w.entry.WithField(LoggerKey, c.name)
+	return &c
+}
+
+// Metrics 返回打印统计
+func (w *LogrusWriter) Metrics() *WriterMetrics {
+	return w.metrics
 }
 
+// Close 只关闭当前writer的文件 logrus的Exit会执行所有writer注册的退出函数 只在fatal时使用
 func (w *LogrusWriter) Close() {
-	w.logger.Exit(1)
+	w.dedup.flush()
+	if w.sinks != nil {
+		w.sinks.exit()
+	}
+}
+
+func (w *LogrusWriter) flush() {
+	w.dedup.flush()
+	if w.sinks != nil {
+		w.sinks.flush()
+	}
 }
 
 func (w *LogrusWriter) SetLevel(level string) {
@@ -269,55 +322,227 @@ func (w *LogrusWriter) SetLevel(level string) {
 }
 
 func (w *LogrusWriter) GetLevel() int {
-	return LogLevel[w.logger.GetLevel().String()]
+	lv := w.logger.GetLevel()
+	switch {
+	case lv >= logrus.DebugLevel:
+		return DebugLevel
+	case lv == logrus.InfoLevel:
+		return InfoLevel
+	case lv == logrus.WarnLevel:
+		return WarnLevel
+	case lv == logrus.ErrorLevel:
+		return ErrorLevel
+	case lv == logrus.FatalLevel:
+		return FatalLevel
+	default:
+		return PanicLevel
+	}
 }
 
 func (w *LogrusWriter) Error(v ...interface{}) {
-	w.logger.Error(fmt.Sprint(v...))
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.entry.Error(fmt.Sprint(v...))
 }
 
 func (w *LogrusWriter) ErrorF(format string, fields ...interface{}) {
-	w.logger.Errorf(format, fields...)
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.entry.Errorf(format, fields...)
 }
 
 func (w *LogrusWriter) ErrorW(format string, fields ...LogField) {
-	w.logger.WithFields(toLogrusFields(fields...)).Error(format)
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(fields...)).Error(format)
 }
 
 func (w *LogrusWriter) Debug(v ...interface{}) {
-	w.logger.Debug(fmt.Sprint(v...))
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.entry.Debug(fmt.Sprint(v...))
 }
 
 func (w *LogrusWriter) DebugF(format string, fields ...interface{}) {
-	w.logger.Debugf(format, fields...)
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.entry.Debugf(format, fields...)
 }
 
 func (w *LogrusWriter) DebugW(format string, fields ...LogField) {
-	w.logger.WithFields(toLogrusFields(fields...)).Debug(format)
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(fields...)).Debug(format)
 }
 
 func (w *LogrusWriter) Info(v ...interface{}) {
-	w.logger.Info(fmt.Sprint(v...))
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.entry.Info(fmt.Sprint(v...))
 }
 
 func (w *LogrusWriter) InfoF(format string, fields ...interface{}) {
-	w.logger.Infof(format, fields...)
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.entry.Infof(format, fields...)
 }
 
 func (w *LogrusWriter) InfoW(format string, fields ...LogField) {
-	w.logger.WithFields(toLogrusFields(fields...)).Info(format)
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(fields...)).Info(format)
 }
 
 func (w *LogrusWriter) Warn(v ...interface{}) {
-	w.logger.Warn(fmt.Sprint(v...))
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.entry.Warn(fmt.Sprint(v...))
 }
 
 func (w *LogrusWriter) WarnF(format string, fields ...interface{}) {
-	w.logger.Warnf(format, fields...)
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.entry.Warnf(format, fields...)
 }
 
 func (w *LogrusWriter) WarnW(format string, fields ...LogField) {
-	w.logger.WithFields(toLogrusFields(fields...)).Warn(format)
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(fields...)).Warn(format)
+}
+
+func (w *LogrusWriter) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	defer panicAfterFlush(msg)
+	w.entry.Panic(msg)
+}
+
+func (w *LogrusWriter) PanicF(format string, fields ...interface{}) {
+	msg := fmt.Sprintf(format, fields...)
+	defer panicAfterFlush(msg)
+	w.entry.Panic(msg)
+}
+
+func (w *LogrusWriter) PanicW(format string, fields ...LogField) {
+	defer panicAfterFlush(format)
+	w.entry.WithFields(toLogrusFields(fields...)).Panic(format)
+}
+
+func (w *LogrusWriter) Fatal(v ...interface{}) {
+	w.entry.Log(logrus.FatalLevel, fmt.Sprint(v...)) //不走logrus的Exit 由exitAfterFlush统一退出
+	w.fatalExit()
+}
+
+func (w *LogrusWriter) FatalF(format string, fields ...interface{}) {
+	w.entry.Logf(logrus.FatalLevel, format, fields...)
+	w.fatalExit()
+}
+
+func (w *LogrusWriter) FatalW(format string, fields ...LogField) {
+	w.entry.WithFields(toLogrusFields(fields...)).Log(logrus.FatalLevel, format)
+	w.fatalExit()
+}
+
+// fatalExit 先执行所有logrus writer注册的退出函数 关闭没有注册到xlog的writer的文件 再刷新所有日志后退出
+func (w *LogrusWriter) fatalExit() {
+	w.logger.Exit(1) //ExitFunc不退出
+	exitAfterFlush()
+}
+
+func (w *LogrusWriter) ErrorCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Error(fmt.Sprint(v...))
+}
+
+func (w *LogrusWriter) ErrorCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Errorf(format, fields...)
+}
+
+func (w *LogrusWriter) ErrorCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(withContextFields(ctx, fields...)...)).Error(format)
+}
+
+func (w *LogrusWriter) DebugCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Debug(fmt.Sprint(v...))
+}
+
+func (w *LogrusWriter) DebugCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Debugf(format, fields...)
+}
+
+func (w *LogrusWriter) DebugCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(withContextFields(ctx, fields...)...)).Debug(format)
+}
+
+func (w *LogrusWriter) InfoCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Info(fmt.Sprint(v...))
+}
+
+func (w *LogrusWriter) InfoCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Infof(format, fields...)
+}
+
+func (w *LogrusWriter) InfoCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(withContextFields(ctx, fields...)...)).Info(format)
+}
+
+func (w *LogrusWriter) WarnCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Warn(fmt.Sprint(v...))
+}
+
+func (w *LogrusWriter) WarnCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(FieldsFromContext(ctx)...)).Warnf(format, fields...)
+}
+
+func (w *LogrusWriter) WarnCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.entry.WithFields(toLogrusFields(withContextFields(ctx, fields...)...)).Warn(format)
 }
 
 func toLogrusFields(fields ...LogField) logrus.Fields {
@@ -330,3 +555,105 @@ func toLogrusFields(fields ...LogField) logrus.Fields {
 	}
 	return logrusFields
 }
+
+// metricsHook 统计logrus每个等级的打印行数
+type metricsHook struct {
+	metrics *WriterMetrics
+}
+
+func (h metricsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h metricsHook) Fire(entry *logrus.Entry) error {
+	switch entry.Level {
+	case logrus.PanicLevel:
+		h.metrics.addLine(PanicLevel)
+	case logrus.FatalLevel:
+		h.metrics.addLine(FatalLevel)
+	case logrus.ErrorLevel:
+		h.metrics.addLine(ErrorLevel)
+	case logrus.WarnLevel:
+		h.metrics.addLine(WarnLevel)
+	case logrus.InfoLevel:
+		h.metrics.addLine(InfoLevel)
+	default:
+		h.metrics.addLine(DebugLevel)
+	}
+	return nil
+}
+
+// redactHook 在格式化之前对内容和字段脱敏 entry在每次打印时已复制 可以直接修改
+type redactHook struct {
+	redact *redaction
+}
+
+func (h redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h redactHook) Fire(entry *logrus.Entry) error {
+	redactor := h.redact.get()
+	entry.Message = redactor.Message(entry.Message)
+	for key, value := range entry.Data {
+		if v, ok := redactor.redactField(key, value); ok {
+			entry.Data[key] = v
+		}
+	}
+	return nil
+}
+
+// dedupHook 合并连续重复的日志后再写文件 控制台输出不经过hook 不合并
+type dedupHook struct {
+	logrus.Hook
+	dedup *deduper
+}
+
+func (h dedupHook) Fire(entry *logrus.Entry) error {
+	if !h.dedup.enabled() {
+		return h.Hook.Fire(entry)
+	}
+	data := make(logrus.Fields, len(entry.Data)) //entry打印后会被复用 重复的汇总在之后打印 需要复制
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	dup := logrus.Entry{Logger: entry.Logger, Time: entry.Time, Level: entry.Level, Caller: entry.Caller,
+		Message: entry.Message, Context: entry.Context}
+	key := dedupKey(entry.Level.String(), entry.Message, nil) + fmt.Sprint(data)
+	h.dedup.log(key, func(extra ...LogField) {
+		e := dup
+		e.Data = make(logrus.Fields, len(data)+len(extra))
+		for k, v := range data {
+			e.Data[k] = v
+		}
+		for _, f := range extra {
+			e.Data[f.Key] = f.Value
+		}
+		if err := h.Hook.Fire(&e); err != nil {
+			log.Println("xlog logrus dedup hook error", err.Error())
+		}
+	})
+	return nil
+}
+
+// logSummary 打印采样汇总 不经过采样
+func (w *LogrusWriter) logSummary(level int, msg string, fields ...LogField) {
+	w.entry.WithFields(toLogrusFields(fields...)).Log(toLogrusLevel(level), msg)
+}
+
+func toLogrusLevel(level int) logrus.Level {
+	switch level {
+	case DebugLevel:
+		return logrus.DebugLevel
+	case InfoLevel:
+		return logrus.InfoLevel
+	case WarnLevel:
+		return logrus.WarnLevel
+	case ErrorLevel:
+		return logrus.ErrorLevel
+	case PanicLevel:
+		return logrus.PanicLevel
+	default:
+		return logrus.FatalLevel
+	}
+}
@@ -11,9 +11,21 @@ import (
 )
 
 func GetLumberjackLogWriter(dir, file string, cfg *config.Lumberjack) LogFileWrite {
+	w, err := NewLumberjackLogWriter(dir, file, cfg)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// NewLumberjackLogWriter 同GetLumberjackLogWriter 出错时返回错误
+func NewLumberjackLogWriter(dir, file string, cfg *config.Lumberjack) (LogFileWrite, error) {
+	if err := makeLogDir(dir); err != nil {
+		return nil, err
+	}
 	if !strings.Contains(file, common.LogFormal) {
 		file = file + common.LogTemp
 	}
 	logger := lumberjack.NewLumberjack(file, dir, cfg.MaxSize, cfg.MaxBackups, cfg.MaxAge, cfg.SplitTime, cfg.Compress, true)
-	return logger
+	return logger, nil
 }
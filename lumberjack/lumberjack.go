@@ -22,7 +22,6 @@
 package lumberjack
 
 import (
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
@@ -33,6 +32,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/crx666/xlog/common"
@@ -107,7 +107,8 @@ type Logger struct {
 	LocalTime bool `json:"localtime" yaml:"localtime"`
 
 	// Compress determines if the rotated log files should be compressed
-	// using gzip. The default is not to perform compression.
+	// using gzip, or the codec given to SetCompressor. The default is not
+	// to perform compression.
 	Compress bool `json:"compress" yaml:"compress"`
 
 	SplitTime int `json:"split_time" yaml:"split_time"`
@@ -123,6 +124,55 @@ type Logger struct {
 
 	millCh    chan bool
 	startMill sync.Once
+
+	handler    atomic.Value // func(Event), read by the mill goroutine without mu
+	compressor common.Compressor
+}
+
+// EventType is the kind of a file event reported to the handler.
+type EventType int
+
+const (
+	FileRotated EventType = iota + 1
+	FileCompressed
+	FileDeleted
+)
+
+// Event describes a rotation, compression or removal of a log file.
+type Event struct {
+	Type     EventType
+	Filename string
+}
+
+// SetHandler sets the function called after a file has been rotated,
+// compressed or removed. It is called synchronously, so it should be fast.
+func (l *Logger) SetHandler(fn func(Event)) {
+	l.handler.Store(fn)
+}
+
+// SetCompressor sets the codec used when Compress is enabled.
+// The default is gzip at the default level.
+func (l *Logger) SetCompressor(c common.Compressor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.compressor = c
+}
+
+// getCompressor returns the configured codec or gzip.
+func (l *Logger) getCompressor() common.Compressor {
+	l.mu.Lock()
+	c := l.compressor
+	l.mu.Unlock()
+	if c == nil {
+		c, _ = common.NewCompressor(common.CompressGzip, 0)
+	}
+	return c
+}
+
+func (l *Logger) emit(typ EventType, filename string) {
+	if fn, _ := l.handler.Load().(func(Event)); fn != nil {
+		fn(Event{Type: typ, Filename: filename})
+	}
 }
 
 var (
@@ -221,11 +271,28 @@ func (l *Logger) Close() error {
 	return l.close()
 }
 
+// Flush syncs the current file to disk and renames it from .temp to .log,
+// keeping it open for further writes.
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return
+	}
+	l.file.Sync()
+	if name, err := common.RenameLogFile(l.Filename); err == nil {
+		l.Filename = name
+	}
+}
+
 func (l *Logger) Exit() error {
 	if l.file == nil {
 		return nil
 	}
-	name := l.file.Name()
+	l.mu.Lock()
+	name := l.Filename
+	l.mu.Unlock()
 	err := l.Close()
 	if err != nil {
 		return err
@@ -237,20 +304,15 @@ func (l *Logger) Exit() error {
 	return err
 }
 
+// Sync commits the current file to disk. Use Exit to close it.
 func (l *Logger) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
 	if l.file == nil {
 		return nil
 	}
-	name := l.file.Name()
-	err := l.Close()
-	if err != nil {
-		return err
-	}
-	if l.SplitTime > 0 {
-		l.closeChan <- struct{}{}
-	}
-	err = common.ReplaceLogName(name)
-	return err
+	return l.file.Sync()
 }
 
 // close closes the file if it is open.
@@ -281,20 +343,25 @@ func (l *Logger) rotate() error {
 	if err := l.close(); err != nil {
 		return err
 	}
-	if err := l.openNew(); err != nil {
+	backup, err := l.openNew()
+	if err != nil {
 		return err
 	}
+	if backup != "" {
+		l.emit(FileRotated, backup)
+	}
 	l.mill()
 	return nil
 }
 
 // openNew opens a new log file for writing, moving any old log file out of the
-// way.  This methods assumes the file has already been closed.
-func (l *Logger) openNew() error {
+// way.  This methods assumes the file has already been closed. It returns the
+// name the old log file was moved to, or "" if there was none.
+func (l *Logger) openNew() (backup string, err error) {
 	dir := common.ReplaceDir(l.dirTemp)
-	err := os.MkdirAll(dir, 0755)
+	err = os.MkdirAll(dir, 0755)
 	if err != nil {
-		return fmt.Errorf("can't make directories for new logfile: %s", err)
+		return "", fmt.Errorf("can't make directories for new logfile: %s", err)
 	}
 
 	name := l.filename()
@@ -304,9 +371,9 @@ func (l *Logger) openNew() error {
 		// Copy the mode off the old logfile.
 		mode = info.Mode()
 		// move the existing file
-		err = common.ReplaceLogName(name)
+		backup, err = common.RenameLogFile(name)
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		//newname := backupName(name, l.LocalTime)
@@ -325,12 +392,12 @@ func (l *Logger) openNew() error {
 	// just wipe out the contents.
 	f, err := os.OpenFile(newName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 	if err != nil {
-		return fmt.Errorf("can't open new logfile: %s", err)
+		return "", fmt.Errorf("can't open new logfile: %s", err)
 	}
 	l.file = f
 	l.Filename = newName
 	l.size = 0
-	return nil
+	return backup, nil
 }
 
 // backupName creates a new filename from the given name, inserting a timestamp
@@ -359,7 +426,8 @@ func (l *Logger) openExistingOrNew(writeLen int) error {
 	filename := l.filename()
 	info, err := osStat(filename)
 	if os.IsNotExist(err) {
-		return l.openNew()
+		_, err = l.openNew()
+		return err
 	}
 	if err != nil {
 		return fmt.Errorf("error getting log file info: %s", err)
@@ -373,7 +441,8 @@ func (l *Logger) openExistingOrNew(writeLen int) error {
 	if err != nil {
 		// if we fail to open the old log file for some reason, just ignore
 		// it and open a new log file.
-		return l.openNew()
+		_, err = l.openNew()
+		return err
 	}
 	l.file = file
 	l.size = info.Size()
@@ -411,10 +480,7 @@ func (l *Logger) millRunOnce() error {
 		for _, f := range files {
 			// Only count the uncompressed log file or the
 			// compressed log file, not both.
-			fn := f.Name()
-			if strings.HasSuffix(fn, compressSuffix) {
-				fn = fn[:len(fn)-len(compressSuffix)]
-			}
+			fn, _ := common.TrimCompressSuffix(f.Name())
 			preserved[fn] = true
 
 			if len(preserved) > l.MaxBackups {
@@ -442,21 +508,29 @@ func (l *Logger) millRunOnce() error {
 
 	if l.Compress {
 		for _, f := range files {
-			if !strings.HasSuffix(f.Name(), compressSuffix) {
+			if _, ok := common.TrimCompressSuffix(f.Name()); !ok {
 				compress = append(compress, f)
 			}
 		}
 	}
 
 	for _, f := range remove {
-		errRemove := os.Remove(filepath.Join(l.dir(), f.Name()))
+		fn := filepath.Join(l.dir(), f.Name())
+		errRemove := os.Remove(fn)
+		if errRemove == nil {
+			l.emit(FileDeleted, fn)
+		}
 		if err == nil && errRemove != nil {
 			err = errRemove
 		}
 	}
+	c := l.getCompressor()
 	for _, f := range compress {
 		fn := filepath.Join(l.dir(), f.Name())
-		errCompress := compressLogFile(fn, fn+compressSuffix)
+		errCompress := compressLogFile(c, fn, fn+c.Suffix())
+		if errCompress == nil {
+			l.emit(FileCompressed, fn+c.Suffix())
+		}
 		if err == nil && errCompress != nil {
 			err = errCompress
 		}
@@ -506,9 +580,11 @@ func (l *Logger) oldLogFiles() ([]logInfo, error) {
 			logFiles = append(logFiles, logInfo{t, f})
 			continue
 		}
-		if t, err := l.timeFromName(f.Name(), prefix, ext+compressSuffix); err == nil {
-			logFiles = append(logFiles, logInfo{t, f})
-			continue
+		if name, ok := common.TrimCompressSuffix(f.Name()); ok {
+			if t, err := l.timeFromName(name, prefix, ext); err == nil {
+				logFiles = append(logFiles, logInfo{t, f})
+				continue
+			}
 		}
 		// error parsing means that the suffix at the end was not generated
 		// by lumberjack, and therefore it's not a backup file.
@@ -542,21 +618,29 @@ func (l *Logger) max() int64 {
 
 // dir returns the directory for the current filename.
 func (l *Logger) dir() string {
-	return filepath.Dir(l.filename())
+	return filepath.Dir(l.currentFilename())
+}
+
+// currentFilename reads the filename under mu, since openNew changes it
+// while the mill goroutine is running. It must not be called with mu held.
+func (l *Logger) currentFilename() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.filename()
 }
 
 // prefixAndExt returns the filename part and extension part from the Logger's
 // filename.
 func (l *Logger) prefixAndExt() (prefix, ext string) {
-	filename := filepath.Base(l.filename())
+	filename := filepath.Base(l.currentFilename())
 	ext = filepath.Ext(filename)
 	prefix = filename[:len(filename)-len(ext)] + "-"
 	return prefix, ext
 }
 
-// compressLogFile compresses the given log file, removing the
+// compressLogFile compresses the given log file with c, removing the
 // uncompressed log file if successful.
-func compressLogFile(src, dst string) (err error) {
+func compressLogFile(c common.Compressor, src, dst string) (err error) {
 	f, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("failed to open log file: %v", err)
@@ -580,7 +664,10 @@ func compressLogFile(src, dst string) (err error) {
 	}
 	defer gzf.Close()
 
-	gz := gzip.NewWriter(gzf)
+	gz, err := c.NewWriter(gzf)
+	if err != nil {
+		return fmt.Errorf("failed to create compressor: %v", err)
+	}
 
 	defer func() {
 		if err != nil {
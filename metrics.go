@@ -0,0 +1,244 @@
+package xlog
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/crx666/xlog/lumberjack"
+	"github.com/crx666/xlog/rotatelogs"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultMetricsMark 默认日志对象未注册到LoggerManager时在统计中使用的名字
+const DefaultMetricsMark = "default"
+
+var levelNames = [FatalLevel + 1]string{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelPanic, LevelFatal}
+
+// WriterMetrics 日志对象的统计 With和Named派生的子logger共享同一份
+type WriterMetrics struct {
+	lines       [FatalLevel + 1]int64
+	bytes       int64
+	writeErrors int64
+	rotations   int64
+	compressed  int64
+	deleted     int64
+}
+
+// MetricsSnapshot 某一时刻的统计值
+type MetricsSnapshot struct {
+	Lines       map[string]int64 `json:"lines"`
+	Bytes       int64            `json:"bytes"`
+	WriteErrors int64            `json:"write_errors"`
+	Rotations   int64            `json:"rotations"`
+	Compressed  int64            `json:"compressed"`
+	Deleted     int64            `json:"deleted"`
+}
+
+func newWriterMetrics() *WriterMetrics {
+	return new(WriterMetrics)
+}
+
+func (m *WriterMetrics) addLine(level int) {
+	if m == nil || level < DebugLevel || level > FatalLevel {
+		return
+	}
+	atomic.AddInt64(&m.lines[level], 1)
+}
+
+func (m *WriterMetrics) addWrite(n int, err error) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.bytes, int64(n))
+	if err != nil {
+		atomic.AddInt64(&m.writeErrors, 1)
+	}
+}
+
+// Lines 某个等级打印的行数
+func (m *WriterMetrics) Lines(level int) int64 {
+	if m == nil || level < DebugLevel || level > FatalLevel {
+		return 0
+	}
+	return atomic.LoadInt64(&m.lines[level])
+}
+
+func (m *WriterMetrics) Snapshot() MetricsSnapshot {
+	s := MetricsSnapshot{Lines: make(map[string]int64, len(levelNames))}
+	if m == nil {
+		return s
+	}
+	for i, name := range levelNames {
+		s.Lines[name] = atomic.LoadInt64(&m.lines[i])
+	}
+	s.Bytes = atomic.LoadInt64(&m.bytes)
+	s.WriteErrors = atomic.LoadInt64(&m.writeErrors)
+	s.Rotations = atomic.LoadInt64(&m.rotations)
+	s.Compressed = atomic.LoadInt64(&m.compressed)
+	s.Deleted = atomic.LoadInt64(&m.deleted)
+	return s
+}
+
+func (m *WriterMetrics) rotateHandler() rotatelogs.Handler {
+	return rotatelogs.HandlerFunc(func(e rotatelogs.Event) {
+		switch e.Type() {
+		case rotatelogs.FileRotatedEventType:
+			atomic.AddInt64(&m.rotations, 1)
+		case rotatelogs.FileDeletedEventType:
+			atomic.AddInt64(&m.deleted, 1)
+		case rotatelogs.FileCompressedEventType:
+			atomic.AddInt64(&m.compressed, 1)
+		}
+	})
+}
+
+func (m *WriterMetrics) lumberjackHandler(e lumberjack.Event) {
+	switch e.Type {
+	case lumberjack.FileRotated:
+		atomic.AddInt64(&m.rotations, 1)
+	case lumberjack.FileCompressed:
+		atomic.AddInt64(&m.compressed, 1)
+	case lumberjack.FileDeleted:
+		atomic.AddInt64(&m.deleted, 1)
+	}
+}
+
+// zapHook zap每打印一行调用一次
+func (m *WriterMetrics) zapHook(entry zapcore.Entry) error {
+	m.addLine(fromZapLevel(entry.Level))
+	return nil
+}
+
+// metricsFile 统计写入底层文件的字节数和失败次数
+type metricsFile struct {
+	LogFileWrite
+	metrics *WriterMetrics
+}
+
+func (f metricsFile) Write(p []byte) (n int, err error) {
+	n, err = f.LogFileWrite.Write(p)
+	f.metrics.addWrite(n, err)
+	return n, err
+}
+
+func (f metricsFile) WriteLevel(level int, p []byte) (n int, err error) {
+	n, err = writeLevel(f.LogFileWrite, level, p)
+	f.metrics.addWrite(n, err)
+	return n, err
+}
+
+func (f metricsFile) Sync() error {
+	return syncLogFile(f.LogFileWrite)
+}
+
+func (f metricsFile) Flush() {
+	flushLogFile(f.LogFileWrite)
+}
+
+// GetMetrics 返回mark对应日志对象的统计 不存在时返回nil
+func GetMetrics(mark string) *WriterMetrics {
+	return metricsOf(loggerMgr.getWriter(mark))
+}
+
+func metricsOf(w Writer) *WriterMetrics {
+	if m, ok := w.(interface{ Metrics() *WriterMetrics }); ok {
+		return m.Metrics()
+	}
+	return nil
+}
+
+// AllMetrics 所有注册日志对象的统计 默认日志对象未注册时以DefaultMetricsMark为名
+func AllMetrics() map[string]MetricsSnapshot {
+	values := make(map[string]MetricsSnapshot)
+	seen := make(map[*WriterMetrics]bool)
+	loggerMgr.RLock()
+	for mark, w := range loggerMgr.LoggerInfo {
+		if m := metricsOf(w); m != nil {
+			values[mark] = m.Snapshot()
+			seen[m] = true
+		}
+	}
+	loggerMgr.RUnlock()
+	if m := metricsOf(writer.GetWriter()); m != nil && !seen[m] {
+		if _, ok := values[DefaultMetricsMark]; !ok {
+			values[DefaultMetricsMark] = m.Snapshot()
+		}
+	}
+	return values
+}
+
+// WriteMetrics 以Prometheus文本格式输出所有统计
+func WriteMetrics(out io.Writer) error {
+	values := AllMetrics()
+	marks := make([]string, 0, len(values))
+	for mark := range values {
+		marks = append(marks, mark)
+	}
+	sort.Strings(marks)
+
+	buf := bufio.NewWriter(out)
+	writeMetricHeader(buf, "xlog_lines_total", "Number of log lines written by level.")
+	for _, mark := range marks {
+		for _, level := range levelNames {
+			fmt.Fprintf(buf, "xlog_lines_total{logger=\"%s\",level=\"%s\"} %d\n", escapeLabel(mark), level, values[mark].Lines[level])
+		}
+	}
+	counters := []struct {
+		name  string
+		help  string
+		value func(s MetricsSnapshot) int64
+	}{
+		{"xlog_bytes_written_total", "Number of bytes written to log files.", func(s MetricsSnapshot) int64 { return s.Bytes }},
+		{"xlog_write_errors_total", "Number of failed writes to log files.", func(s MetricsSnapshot) int64 { return s.WriteErrors }},
+		{"xlog_rotations_total", "Number of log file rotations.", func(s MetricsSnapshot) int64 { return s.Rotations }},
+		{"xlog_files_compressed_total", "Number of rotated log files compressed.", func(s MetricsSnapshot) int64 { return s.Compressed }},
+		{"xlog_files_deleted_total", "Number of old log files deleted.", func(s MetricsSnapshot) int64 { return s.Deleted }},
+	}
+	for _, c := range counters {
+		writeMetricHeader(buf, c.name, c.help)
+		for _, mark := range marks {
+			fmt.Fprintf(buf, "%s{logger=\"%s\"} %d\n", c.name, escapeLabel(mark), c.value(values[mark]))
+		}
+	}
+	writeMetricHeader(buf, "xlog_async_dropped_total", "Number of log lines dropped by async log files.")
+	for i, level := range levelNames {
+		fmt.Fprintf(buf, "xlog_async_dropped_total{level=\"%s\"} %d\n", level, AsyncDroppedByLevel(i))
+	}
+	return buf.Flush()
+}
+
+func writeMetricHeader(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelReplacer.Replace(v)
+}
+
+// MetricsHandler 以Prometheus文本格式输出统计的http.Handler
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
+
+var publishOnce sync.Once
+
+// PublishExpvar 把统计以xlog为名发布到expvar 多次调用只发布一次
+func PublishExpvar() {
+	publishOnce.Do(func() {
+		expvar.Publish("xlog", expvar.Func(func() interface{} {
+			return AllMetrics()
+		}))
+	})
+}
@@ -0,0 +1,458 @@
+package xlog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crx666/xlog/common"
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	NetworkTCP      = "tcp"
+	NetworkUDP      = "udp"
+	NetworkUnix     = "unix"
+	NetworkUnixgram = "unixgram"
+
+	DefaultNetworkDialTimeout  = 3 * time.Second
+	DefaultNetworkWriteTimeout = 3 * time.Second
+	DefaultNetworkMinBackoff   = 500 * time.Millisecond
+	DefaultNetworkMaxBackoff   = 30 * time.Second
+	DefaultSpoolMaxSize        = 100 //单位:MB
+
+	SpoolSuffix = ".spool"
+)
+
+var (
+	ErrUnknownNetwork   = errors.New("unknown network protocol")
+	ErrNoNetworkAddress = errors.New("network address is empty")
+	ErrNetworkConflict  = errors.New("network can not be set with rotatelog or lumberjack")
+	ErrNetworkTLS       = errors.New("invalid network tls config")
+	ErrNetworkDown      = errors.New("network log endpoint is down")
+	ErrNetworkClosed    = errors.New("network log file closed")
+	ErrSpoolFull        = errors.New("network log spool is full")
+)
+
+// NetworkLogFile 通过tcp udp unix socket发送日志 每次Write为一行或多行完整的日志
+// 断线后按退避间隔在后台重连 断线期间写到spool文件 重连后先重放spool再继续发送
+// 重放时不持有锁 期间的写入继续追加到spool 保证发送顺序
+// 没有配置spool时断线期间的日志丢弃并返回ErrNetworkDown
+type NetworkLogFile struct {
+	protocol     string
+	address      string
+	tlsConfig    *tls.Config
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	spoolName    string
+	spoolMax     int64
+
+	mu           sync.Mutex
+	conn         net.Conn
+	spool        *os.File
+	spoolSize    int64
+	reconnecting bool
+	replaying    bool
+	closed       bool
+	done         chan struct{}
+	wg           sync.WaitGroup
+}
+
+var _ LogFileWrite = (*NetworkLogFile)(nil)
+
+// NewNetworkLogWriter 根据配置创建网络日志 开启spool时spool文件为dir下的file.spool
+func NewNetworkLogWriter(dir, file string, cfg *config.Network) (LogFileWrite, error) {
+	var spoolName string
+	if cfg.Spool {
+		if err := makeLogDir(dir); err != nil {
+			return nil, err
+		}
+		spoolName = filepath.Join(common.ReplaceDir(dir), spoolFileName(file))
+	}
+	return NewNetworkLogFile(cfg, spoolName)
+}
+
+// spoolFileName 去掉每次启动都会变化的$ti和$rand 重启后使用同一个spool文件 才能重放上次没发出去的日志
+func spoolFileName(file string) string {
+	name := strings.NewReplacer("_$ti", "", "_$rand", "", "$ti", "", "$rand", "").Replace(file)
+	name = strings.Trim(common.ReplaceName(name), "_")
+	name = strings.TrimSuffix(name, common.LogFormal)
+	if name == "" {
+		name = "network"
+	}
+	return name + SpoolSuffix
+}
+
+// NewNetworkLogFile spoolName为空时不使用spool 首次连接失败不返回错误 在后台重连
+func NewNetworkLogFile(cfg *config.Network, spoolName string) (*NetworkLogFile, error) {
+	if err := validateNetwork(cfg); err != nil {
+		return nil, err
+	}
+	n := &NetworkLogFile{
+		protocol:     strings.ToLower(cfg.Protocol),
+		address:      cfg.Address,
+		dialTimeout:  millisOr(cfg.DialTimeout, DefaultNetworkDialTimeout),
+		writeTimeout: millisOr(cfg.WriteTimeout, DefaultNetworkWriteTimeout),
+		minBackoff:   millisOr(cfg.MinBackoff, DefaultNetworkMinBackoff),
+		maxBackoff:   millisOr(cfg.MaxBackoff, DefaultNetworkMaxBackoff),
+		spoolName:    spoolName,
+		spoolMax:     int64(cfg.SpoolMaxSize) * 1024 * 1024,
+		done:         make(chan struct{}),
+	}
+	if n.protocol == "" {
+		n.protocol = NetworkTCP
+	}
+	if n.maxBackoff < n.minBackoff {
+		n.maxBackoff = n.minBackoff
+	}
+	if n.spoolMax <= 0 {
+		n.spoolMax = DefaultSpoolMaxSize * 1024 * 1024
+	}
+	if cfg.Tls != nil {
+		tlsConfig, err := newNetworkTLS(cfg.Tls, cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		n.tlsConfig = tlsConfig
+	}
+	if spoolName != "" {
+		if err := n.openSpool(); err != nil {
+			return nil, newConfigError("network.spool", spoolName, fmt.Errorf("%w: %s", ErrOpenLogFile, err.Error()))
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	conn, err := n.dial()
+	if err != nil {
+		n.startReconnectLocked()
+		return n, nil
+	}
+	n.setConnLocked(conn)
+	if n.spoolSize > 0 { //上次未发送的日志在后台重放
+		n.replaying, n.reconnecting = true, true
+		n.wg.Add(1)
+		go n.reconnect(conn)
+	}
+	return n, nil
+}
+
+func millisOr(ms int, def time.Duration) time.Duration {
+	if ms > 0 {
+		return time.Duration(ms) * time.Millisecond
+	}
+	return def
+}
+
+func validateNetwork(cfg *config.Network) error {
+	protocol := strings.ToLower(cfg.Protocol)
+	switch protocol {
+	case "", NetworkTCP, NetworkUDP, NetworkUnix, NetworkUnixgram:
+	default:
+		return newConfigError("network.protocol", cfg.Protocol, ErrUnknownNetwork)
+	}
+	if cfg.Address == "" {
+		return newConfigError("network.address", cfg.Address, ErrNoNetworkAddress)
+	}
+	if cfg.Tls != nil && protocol != "" && protocol != NetworkTCP {
+		return newConfigError("network.tls", protocol, ErrNetworkTLS)
+	}
+	return checkNegative(
+		intField{"network.dial_timeout", cfg.DialTimeout},
+		intField{"network.write_timeout", cfg.WriteTimeout},
+		intField{"network.min_backoff", cfg.MinBackoff},
+		intField{"network.max_backoff", cfg.MaxBackoff},
+		intField{"network.spool_max_size", cfg.SpoolMaxSize},
+	)
+}
+
+func newNetworkTLS(cfg *config.NetworkTLS, address string) (*tls.Config, error) {
+	c := &tls.Config{ServerName: cfg.ServerName, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if c.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			c.ServerName = host
+		}
+	}
+	if cfg.CaFile != "" {
+		data, err := ioutil.ReadFile(cfg.CaFile)
+		if err != nil {
+			return nil, newConfigError("network.tls.ca_file", cfg.CaFile, fmt.Errorf("%w: %s", ErrNetworkTLS, err.Error()))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, newConfigError("network.tls.ca_file", cfg.CaFile, ErrNetworkTLS)
+		}
+		c.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, newConfigError("network.tls.cert_file", cfg.CertFile, fmt.Errorf("%w: %s", ErrNetworkTLS, err.Error()))
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+func (n *NetworkLogFile) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: n.dialTimeout}
+	if n.tlsConfig != nil {
+		return tls.DialWithDialer(d, NetworkTCP, n.address, n.tlsConfig)
+	}
+	return d.Dial(n.protocol, n.address)
+}
+
+func (n *NetworkLogFile) Write(p []byte) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return 0, ErrNetworkClosed
+	}
+	if conn := n.conn; conn != nil && !n.replaying {
+		written, err := n.send(conn, p)
+		if err == nil {
+			return len(p), nil
+		}
+		n.dropConnLocked(conn)
+		m, err := n.spoolLocked(p[written:]) //已发送的部分不再写入spool
+		return written + m, err
+	}
+	return n.spoolLocked(p)
+}
+
+func (n *NetworkLogFile) send(conn net.Conn, p []byte) (int, error) {
+	conn.SetWriteDeadline(time.Now().Add(n.writeTimeout))
+	return conn.Write(p)
+}
+
+// Connected 当前是否已连接
+func (n *NetworkLogFile) Connected() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.conn != nil
+}
+
+// Spooled spool文件中等待重放的字节数
+func (n *NetworkLogFile) Spooled() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.spoolSize
+}
+
+// Exit 关闭连接和spool 未重放的日志留在spool文件中 下次启动时重放
+func (n *NetworkLogFile) Exit() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.done)
+	var err error
+	if n.conn != nil {
+		err = n.conn.Close()
+		n.conn = nil
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+	if n.spool != nil {
+		err = firstErr(n.spool.Close(), err)
+	}
+	return err
+}
+
+func (n *NetworkLogFile) setConnLocked(conn net.Conn) {
+	n.conn = conn
+	if n.protocol == NetworkUDP || n.protocol == NetworkUnixgram {
+		return
+	}
+	go func() { //流式连接对端关闭时读返回错误 及时切到spool
+		io.Copy(ioutil.Discard, conn)
+		n.mu.Lock()
+		n.dropConnLocked(conn)
+		n.mu.Unlock()
+	}()
+}
+
+// dropConnLocked conn仍是当前连接时关闭并开始重连
+func (n *NetworkLogFile) dropConnLocked(conn net.Conn) {
+	if n.conn != conn {
+		return
+	}
+	conn.Close()
+	n.conn = nil
+	n.startReconnectLocked()
+}
+
+func (n *NetworkLogFile) startReconnectLocked() {
+	if n.reconnecting || n.closed {
+		return
+	}
+	n.reconnecting = true
+	n.wg.Add(1)
+	go n.reconnect(nil)
+}
+
+// reconnect 按退避间隔重连 每次失败间隔翻倍 最大maxBackoff 连上后重放spool conn不为空时直接重放
+func (n *NetworkLogFile) reconnect(conn net.Conn) {
+	defer n.wg.Done()
+	backoff := n.minBackoff
+	for {
+		if conn == nil {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-n.done:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			c, err := n.dial()
+			n.mu.Lock()
+			if n.closed {
+				n.mu.Unlock()
+				if c != nil {
+					c.Close()
+				}
+				return
+			}
+			if err == nil {
+				conn = c
+				n.setConnLocked(conn)
+				n.replaying = n.spoolSize > 0
+			}
+			n.mu.Unlock()
+		}
+		if conn != nil && n.replay(conn) == nil {
+			return
+		}
+		conn = nil
+		if backoff *= 2; backoff > n.maxBackoff {
+			backoff = n.maxBackoff
+		}
+	}
+}
+
+func (n *NetworkLogFile) openSpool() error {
+	file, err := os.OpenFile(n.spoolName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	n.spool, n.spoolSize = file, info.Size()
+	return nil
+}
+
+func (n *NetworkLogFile) spoolLocked(p []byte) (int, error) {
+	if n.spool == nil {
+		return 0, ErrNetworkDown
+	}
+	if n.spoolSize+int64(len(p)) > n.spoolMax {
+		return 0, ErrSpoolFull
+	}
+	written, err := n.spool.Write(p)
+	n.spoolSize += int64(written)
+	return written, err
+}
+
+// replay 在锁外按行重放spool 重放期间新的写入追加到spool 直到全部发送后清空spool并恢复直接发送
+// 失败时只保留未发送的部分
+func (n *NetworkLogFile) replay(conn net.Conn) error {
+	var offset int64
+	for {
+		n.mu.Lock()
+		if n.closed {
+			n.compactSpoolLocked(offset)
+			n.mu.Unlock()
+			return nil
+		}
+		size := n.spoolSize
+		if offset >= size {
+			if size > 0 {
+				if err := n.spool.Truncate(0); err != nil {
+					log.Println("xlog truncate spool error", err.Error())
+				}
+				n.spoolSize = 0
+			}
+			n.replaying, n.reconnecting = false, false
+			n.mu.Unlock()
+			return nil
+		}
+		n.mu.Unlock()
+		sent, err := n.sendSpool(conn, offset, size)
+		offset += sent
+		if err != nil {
+			n.mu.Lock()
+			n.compactSpoolLocked(offset)
+			n.replaying = false
+			n.dropConnLocked(conn)
+			n.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// sendSpool 发送spool中offset到size之间的内容 返回已发送的字节数
+func (n *NetworkLogFile) sendSpool(conn net.Conn, offset, size int64) (int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(n.spool, offset, size-offset))
+	var sent int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			written, sendErr := n.send(conn, line)
+			sent += int64(written)
+			if sendErr != nil {
+				return sent, sendErr
+			}
+		}
+		if err == io.EOF {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+}
+
+// compactSpoolLocked 去掉spool中前offset个已发送的字节
+func (n *NetworkLogFile) compactSpoolLocked(offset int64) {
+	if offset <= 0 {
+		return
+	}
+	tmpName := n.spoolName + common.LogTemp
+	err := func() error {
+		tmp, err := os.Create(tmpName)
+		if err != nil {
+			return err
+		}
+		if _, err = n.spool.Seek(offset, io.SeekStart); err == nil {
+			_, err = io.Copy(tmp, n.spool)
+		}
+		if err = firstErr(err, tmp.Close()); err != nil {
+			os.Remove(tmpName)
+			return err
+		}
+		n.spool.Close()
+		err = os.Rename(tmpName, n.spoolName)
+		return firstErr(err, n.openSpool())
+	}()
+	if err != nil {
+		log.Println("xlog compact spool error", err.Error())
+	}
+}
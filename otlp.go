@@ -0,0 +1,210 @@
+package xlog
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OtlpScopeName = "github.com/crx666/xlog"
+
+	OtlpServiceNameKey = "service.name"
+	OtlpHostNameKey    = "host.name"
+	OtlpProcessPidKey  = "process.pid"
+)
+
+// otlpSeverity 日志等级对应的OTLP SeverityNumber
+var otlpSeverity = [FatalLevel + 1]int{
+	DebugLevel: 5,  //DEBUG
+	InfoLevel:  9,  //INFO
+	WarnLevel:  13, //WARN
+	ErrorLevel: 17, //ERROR
+	PanicLevel: 21, //FATAL
+	FatalLevel: 21, //FATAL
+}
+
+// otlpAnyValue OTLP/JSON的AnyValue 只会设置其中一个字段 int64按proto3的json规则编码成字符串
+type otlpAnyValue struct {
+	StringValue *string        `json:"stringValue,omitempty"`
+	BoolValue   *bool          `json:"boolValue,omitempty"`
+	IntValue    *string        `json:"intValue,omitempty"`
+	DoubleValue *float64       `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArray     `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValues `json:"kvlistValue,omitempty"`
+}
+
+type otlpArray struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+type otlpKeyValues struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+	TraceId              string         `json:"traceId,omitempty"`
+	SpanId               string         `json:"spanId,omitempty"`
+}
+
+type otlpScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+// otlpLogsRequest OTLP/HTTP的ExportLogsServiceRequest
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// otlpResource 默认带上进程名 主机名和pid labels中的同名属性覆盖默认值
+func otlpResource(labels map[string]string) []otlpKeyValue {
+	values := map[string]string{
+		OtlpServiceNameKey: filepath.Base(os.Args[0]),
+		OtlpProcessPidKey:  strconv.Itoa(os.Getpid()),
+	}
+	if host, err := os.Hostname(); err == nil {
+		values[OtlpHostNameKey] = host
+	}
+	for k, v := range labels {
+		values[k] = v
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		value := otlpValue(values[k])
+		if k == OtlpProcessPidKey {
+			pid := values[k]
+			value = otlpAnyValue{IntValue: &pid}
+		}
+		attrs = append(attrs, otlpKeyValue{Key: k, Value: value})
+	}
+	return attrs
+}
+
+// otlpExport 把一批日志转成一个ExportLogsServiceRequest
+func (h *HttpLogFile) otlpExport(batch []httpEntry) otlpLogsRequest {
+	scope := otlpScopeLogs{LogRecords: make([]otlpLogRecord, 0, len(batch))}
+	scope.Scope.Name = OtlpScopeName
+	for _, entry := range batch {
+		scope.LogRecords = append(scope.LogRecords, otlpRecord(entry))
+	}
+	resource := otlpResourceLogs{ScopeLogs: []otlpScopeLogs{scope}}
+	resource.Resource.Attributes = h.resource
+	return otlpLogsRequest{ResourceLogs: []otlpResourceLogs{resource}}
+}
+
+// otlpRecord trace id和span id字段是合法的十六进制id时作为记录的TraceId和SpanId 其他字段作为属性
+func otlpRecord(entry httpEntry) otlpLogRecord {
+	ts := strconv.FormatInt(entry.ts.UnixNano(), 10)
+	_, msg, fields, ok := parseLineEntry(entry.line) //等级使用写入时传入的 行中只取内容和字段
+	if !ok {
+		msg = string(entry.line)
+	}
+	record := otlpLogRecord{
+		TimeUnixNano:         ts,
+		ObservedTimeUnixNano: ts,
+		SeverityNumber:       otlpSeverity[entry.level],
+		SeverityText:         strings.ToUpper(levelName(entry.level)),
+		Body:                 otlpValue(msg),
+	}
+	traceKey, spanKey := TraceFieldKeys()
+	if id, ok := fields[traceKey].(string); ok && isHexId(id, 16) {
+		record.TraceId = strings.ToLower(id)
+		delete(fields, traceKey)
+	}
+	if id, ok := fields[spanKey].(string); ok && isHexId(id, 8) {
+		record.SpanId = strings.ToLower(id)
+		delete(fields, spanKey)
+	}
+	record.Attributes = otlpAttributes(fields)
+	return record
+}
+
+func otlpAttributes(fields map[string]interface{}) []otlpKeyValue {
+	if len(fields) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, otlpKeyValue{Key: k, Value: otlpValue(fields[k])})
+	}
+	return values
+}
+
+// otlpValue json解码后的值转成AnyValue 整数为intValue 其他数字为doubleValue
+func otlpValue(v interface{}) otlpAnyValue {
+	switch value := v.(type) {
+	case nil:
+		return otlpAnyValue{}
+	case string:
+		return otlpAnyValue{StringValue: &value}
+	case bool:
+		return otlpAnyValue{BoolValue: &value}
+	case json.Number:
+		if _, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			s := string(value)
+			return otlpAnyValue{IntValue: &s}
+		}
+		f, _ := value.Float64()
+		return otlpAnyValue{DoubleValue: &f}
+	case []interface{}:
+		array := &otlpArray{Values: make([]otlpAnyValue, 0, len(value))}
+		for _, item := range value {
+			array.Values = append(array.Values, otlpValue(item))
+		}
+		return otlpAnyValue{ArrayValue: array}
+	case map[string]interface{}:
+		return otlpAnyValue{KvlistValue: &otlpKeyValues{Values: otlpAttributes(value)}}
+	default:
+		s := syslogValue(value)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+// isHexId 是否为n个字节的十六进制id 全0的id无效
+func isHexId(id string, n int) bool {
+	data, err := hex.DecodeString(id)
+	if err != nil || len(data) != n {
+		return false
+	}
+	for _, b := range data {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
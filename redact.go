@@ -0,0 +1,424 @@
+package xlog
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	DefaultRedactMask = "******"
+	RedactTag         = "xlog" //结构体标签 xlog:"redact"整体替换 xlog:"mask=last4"只保留后4位 xlog:"mask=first4"只保留前4位
+)
+
+var ErrInvalidRedactRule = errors.New("invalid redact rule")
+
+// defaultRedactor 没有配置redact时只处理结构体标签
+var defaultRedactor, _ = NewRedactor(nil)
+
+// Redactor 脱敏规则 按字段名 日志内容正则和结构体标签替换敏感信息
+type Redactor struct {
+	keys     []string
+	patterns []*regexp.Regexp
+	mask     string
+	types    sync.Map //reflect.Type -> bool 该类型的值是否需要处理
+}
+
+// NewRedactor 根据配置创建脱敏规则 cfg为空时只处理结构体标签
+func NewRedactor(cfg *config.Redact) (*Redactor, error) {
+	r := &Redactor{mask: DefaultRedactMask}
+	if cfg == nil {
+		return r, nil
+	}
+	if cfg.Mask != "" {
+		r.mask = cfg.Mask
+	}
+	for _, key := range cfg.Keys {
+		key = strings.ToLower(key)
+		if _, err := path.Match(key, ""); err != nil {
+			return nil, newConfigError("redact.keys", key, fmt.Errorf("%w: %s", ErrInvalidRedactRule, err.Error()))
+		}
+		r.keys = append(r.keys, key)
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, newConfigError("redact.patterns", pattern, fmt.Errorf("%w: %s", ErrInvalidRedactRule, err.Error()))
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// MatchKey 字段名是否需要脱敏
+func (r *Redactor) MatchKey(key string) bool {
+	if len(r.keys) <= 0 {
+		return false
+	}
+	key = strings.ToLower(key)
+	for _, rule := range r.keys {
+		if ok, _ := path.Match(rule, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Message 按正则替换日志内容中的敏感信息
+func (r *Redactor) Message(msg string) string {
+	for _, re := range r.patterns {
+		msg = re.ReplaceAllString(msg, r.mask)
+	}
+	return msg
+}
+
+// Fields 返回脱敏后的字段 不需要处理时返回原切片
+func (r *Redactor) Fields(fields []LogField) []LogField {
+	var values []LogField
+	for i, field := range fields {
+		value, changed := r.redactField(field.Key, field.Value)
+		if !changed {
+			continue
+		}
+		if values == nil {
+			values = make([]LogField, len(fields))
+			copy(values, fields)
+		}
+		values[i] = LogField{Key: field.Key, Value: value}
+	}
+	if values == nil {
+		return fields
+	}
+	return values
+}
+
+// Value 处理结构体标签 map和结构体中匹配的字段名 有规则匹配时结构体才会按json的规则转成map
+// 没有匹配的值和实现了json.Marshaler的类型原样返回
+func (r *Redactor) Value(v interface{}) interface{} {
+	value, _ := r.redactValue(v)
+	return value
+}
+
+func (r *Redactor) redactField(key string, v interface{}) (interface{}, bool) {
+	if r.MatchKey(key) {
+		return r.mask, true
+	}
+	return r.redactValue(v)
+}
+
+func (r *Redactor) redactValue(v interface{}) (interface{}, bool) {
+	switch v.(type) { //常见类型不需要反射
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		float32, float64, []byte, []string, []int:
+		return v, false
+	}
+	rv := reflect.ValueOf(v)
+	if !r.needRedact(rv.Type()) {
+		return v, false
+	}
+	value, changed := r.value(rv)
+	if !changed {
+		return v, false
+	}
+	return value, true
+}
+
+// needRedact 类型中是否有标签 可能匹配的字段名或者interface 结果按类型缓存
+func (r *Redactor) needRedact(t reflect.Type) bool {
+	if need, ok := r.types.Load(t); ok {
+		return need.(bool)
+	}
+	need := r.checkType(t, make(map[reflect.Type]bool))
+	r.types.Store(t, need)
+	return need
+}
+
+func (r *Redactor) checkType(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] { //递归类型 由外层决定
+		return false
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return r.checkType(t.Elem(), visiting)
+	case reflect.Map:
+		if t.Key().Kind() == reflect.String && len(r.keys) > 0 {
+			return true
+		}
+		return r.checkType(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" { //未导出字段不会被编码
+				continue
+			}
+			if f.Tag.Get(RedactTag) != "" || r.MatchKey(fieldName(f)) || r.checkType(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// isMarshaler 自定义了编码的类型 编码结果和字段无关 不处理
+func isMarshaler(rv reflect.Value) bool {
+	t := rv.Type()
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return true
+	}
+	if rv.CanAddr() {
+		pt := reflect.PtrTo(t)
+		return pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType)
+	}
+	return false
+}
+
+// value 返回脱敏后的值 changed为false时没有规则匹配 调用方使用原值
+func (r *Redactor) value(rv reflect.Value) (interface{}, bool) {
+	if !rv.IsValid() || !rv.CanInterface() {
+		return nil, false
+	}
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface) && rv.IsNil() {
+		return nil, false
+	}
+	if isMarshaler(rv) || !r.needRedact(rv.Type()) {
+		return rv.Interface(), false
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return r.value(rv.Elem())
+	case reflect.Struct:
+		values, changed := r.structValue(rv)
+		if !changed {
+			return rv.Interface(), false
+		}
+		return values, true
+	case reflect.Map:
+		if rv.IsNil() {
+			return rv.Interface(), false
+		}
+		changed := false
+		if rv.Type().Key().Kind() != reflect.String {
+			values := make(map[interface{}]interface{}, rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				v, c := r.value(iter.Value())
+				values[iter.Key().Interface()] = v
+				changed = changed || c
+			}
+			if !changed {
+				return rv.Interface(), false
+			}
+			return values, true
+		}
+		values := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if r.MatchKey(key) {
+				values[key] = r.mask
+				changed = true
+				continue
+			}
+			v, c := r.value(iter.Value())
+			values[key] = v
+			changed = changed || c
+		}
+		if !changed {
+			return rv.Interface(), false
+		}
+		return values, true
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return rv.Interface(), false
+		}
+		changed := false
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			var c bool
+			values[i], c = r.value(rv.Index(i))
+			changed = changed || c
+		}
+		if !changed {
+			return rv.Interface(), false
+		}
+		return values, true
+	default:
+		return rv.Interface(), false
+	}
+}
+
+// structValue 按json的规则把结构体转成map 匿名结构体字段展开到外层 外层的同名字段优先
+func (r *Redactor) structValue(rv reflect.Value) (map[string]interface{}, bool) {
+	t := rv.Type()
+	values := make(map[string]interface{}, t.NumField())
+	embedded := make(map[string]interface{})
+	changed := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { //未导出字段不会被编码
+			continue
+		}
+		name, opts := jsonTag(f)
+		if name == "-" && opts == "" {
+			continue
+		}
+		fv := rv.Field(i)
+		if f.Anonymous && name == "" && indirectType(f.Type).Kind() == reflect.Struct {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if !isMarshaler(fv) {
+				sub, c := r.structValue(fv)
+				for k, v := range sub {
+					if _, ok := embedded[k]; !ok {
+						embedded[k] = v
+					}
+				}
+				changed = changed || c
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		tag := f.Tag.Get(RedactTag)
+		switch {
+		case tag == "redact" || r.MatchKey(name):
+			values[name] = r.mask
+			changed = true
+		case strings.HasPrefix(tag, "mask=") && fv.Kind() == reflect.Ptr && fv.IsNil():
+			values[name] = nil
+		case strings.HasPrefix(tag, "mask="):
+			values[name] = maskString(fmt.Sprint(reflect.Indirect(fv).Interface()), strings.TrimPrefix(tag, "mask="))
+			changed = true
+		default:
+			v, c := r.value(fv)
+			values[name] = v
+			changed = changed || c
+		}
+	}
+	for k, v := range embedded {
+		if _, ok := values[k]; !ok {
+			values[k] = v
+		}
+	}
+	return values, changed
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// isEmptyValue 同encoding/json omitempty的判断
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonTag json标签中的名字和选项
+func jsonTag(f reflect.StructField) (string, string) {
+	tag := f.Tag.Get("json")
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// fieldName 优先使用json标签中的名字 和编码后的key保持一致
+func fieldName(f reflect.StructField) string {
+	if tag := f.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// maskString rule为lastN时只保留后N个字符 firstN时只保留前N个字符 其他全部替换
+func maskString(s, rule string) string {
+	runes := []rune(s)
+	keep, fromEnd := 0, true
+	switch {
+	case strings.HasPrefix(rule, "last"):
+		keep, _ = strconv.Atoi(strings.TrimPrefix(rule, "last"))
+	case strings.HasPrefix(rule, "first"):
+		keep, _ = strconv.Atoi(strings.TrimPrefix(rule, "first"))
+		fromEnd = false
+	}
+	if keep <= 0 || keep >= len(runes) {
+		return strings.Repeat("*", len(runes))
+	}
+	masked := strings.Repeat("*", len(runes)-keep)
+	if fromEnd {
+		return masked + string(runes[len(runes)-keep:])
+	}
+	return string(runes[:keep]) + masked
+}
+
+// redaction 可在运行时替换的脱敏规则 由派生的子logger共享
+type redaction struct {
+	v atomic.Value //*Redactor
+}
+
+func newRedaction() *redaction {
+	r := new(redaction)
+	r.v.Store(defaultRedactor)
+	return r
+}
+
+func (r *redaction) get() *Redactor {
+	if r == nil {
+		return defaultRedactor
+	}
+	return r.v.Load().(*Redactor)
+}
+
+func (r *redaction) apply(cfg *config.Redact) error {
+	rd, err := NewRedactor(cfg)
+	if err != nil {
+		return err
+	}
+	r.v.Store(rd)
+	return nil
+}
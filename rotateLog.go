@@ -1,6 +1,8 @@
 package xlog
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -9,17 +11,40 @@ import (
 	"github.com/crx666/xlog/common"
 
 	"github.com/crx666/xlog/rotatelogs"
+	strftime "github.com/lestrrat/go-strftime"
 )
 
+// DefaultRotateMaxAge rotatelog没有配置任何清理条件时的保存时间
+const DefaultRotateMaxAge = 7 * 24 * time.Hour
+
 func GetRotateLogWriter(dir, file string, cfg *config.Rotatelog) LogFileWrite {
+	w, err := NewRotateLogWriter(dir, file, cfg)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// NewRotateLogWriter 同GetRotateLogWriter 出错时返回错误
+func NewRotateLogWriter(dir, file string, cfg *config.Rotatelog) (LogFileWrite, error) {
+	return newRotateLogWriter(dir, file, cfg)
+}
+
+func newRotateLogWriter(dir, file string, cfg *config.Rotatelog, opts ...rotatelogs.Option) (LogFileWrite, error) {
 	var ti time.Duration
-	if !strings.Contains(file, "$ti") && !strings.Contains(file, "$day") && !strings.Contains(file, "$hour") && !strings.Contains(file, "$minute") {
-		panic("split time log file need time format. please use $ti or $day or $hour or $minute of logName")
+	if err := checkRotateName("log_name", file); err != nil {
+		return nil, err
+	}
+	if err := makeLogDir(dir); err != nil {
+		return nil, err
 	}
 	if !strings.Contains(file, common.LogFormal) {
 		file = file + common.LogTemp
 	}
 
+	if cfg.SplitDay <= 0 && cfg.SplitHour <= 0 && cfg.SplitMinute <= 0 {
+		return nil, newConfigError("rotatelog", "", ErrNoSplitTime)
+	}
 	if cfg.SplitDay > 0 { //按天切分
 		ti = time.Duration(cfg.SplitDay*24) * time.Hour
 	} else if cfg.SplitHour > 0 { //按小时切分
@@ -28,20 +53,99 @@ func GetRotateLogWriter(dir, file string, cfg *config.Rotatelog) LogFileWrite {
 		ti = time.Duration(cfg.SplitMinute) * time.Minute
 	}
 
+	loc, err := rotateLocation(cfg.Location)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := rotateMaxAge(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	options := []rotatelogs.Option{
-		rotatelogs.WithRotationCount(cfg.MaxSave), // 文件最大保存份数
-		rotatelogs.WithRotationTime(ti),           // 日志切割时间间隔
+		rotatelogs.WithRotationCount(cfg.MaxSave),                                   // 文件最大保存份数
+		rotatelogs.WithMaxAge(maxAge),                                               // 文件最长保存时间
+		rotatelogs.WithMaxTotalSize(int64(cfg.MaxTotalSize) << 20),                  // 所有文件的总大小上限
+		rotatelogs.WithRotationTime(ti),                                             // 日志切割时间间隔
+		rotatelogs.WithRotationOffset(time.Duration(cfg.SplitOffset) * time.Minute), // 切割时间点按整分整点整天对齐后的偏移
+	}
+	if loc != nil {
+		options = append(options, rotatelogs.WithLocation(loc)) // 切割时间点和文件名使用的时区
+	}
+	if cfg.Compress {
+		options = append(options, rotatelogs.WithCompress(true)) // 切割后压缩
 	}
 	if cfg.LinkName != "" {
 		options = append(options, rotatelogs.WithLinkName(cfg.LinkName)) // 生成软链，指向最新日志文件
 	}
+	options = append(options, opts...)
 	hook, err := rotatelogs.New(
 		file,
 		dir,
 		options...,
 	)
 	if err != nil {
-		panic(err)
+		return nil, newConfigError("log_name", file, err)
+	}
+	return hook, nil
+}
+
+// checkRotateName 切分的文件名需要时间占位符 支持strftime格式 如app_%Y-%m-%dT%H
+func checkRotateName(field, name string) error {
+	if !common.HasTimePlaceholder(name) {
+		return newConfigError(field, name, ErrNoTimePlaceholder)
+	}
+	if _, err := strftime.New(common.ReplaceName(name)); err != nil {
+		return newConfigError(field, name, fmt.Errorf("%w: %s", ErrInvalidTimeFormat, err.Error()))
+	}
+	return nil
+}
+
+// rotateMaxAge max_age max_save max_total_size都没有配置时默认保存7天 只配置了后两者时不按时间清理
+func rotateMaxAge(cfg *config.Rotatelog) (time.Duration, error) {
+	if cfg.MaxAge == "" {
+		if cfg.MaxSave > 0 || cfg.MaxTotalSize > 0 {
+			return 0, nil
+		}
+		return DefaultRotateMaxAge, nil
+	}
+	d, err := parseRetention(cfg.MaxAge)
+	if err != nil {
+		return 0, newConfigError("rotatelog.max_age", cfg.MaxAge, fmt.Errorf("%w: %s", ErrInvalidDuration, err.Error()))
+	}
+	return d, nil
+}
+
+// parseRetention 同time.ParseDuration 另外支持以d结尾的天数 如7d
+func parseRetention(s string) (time.Duration, error) {
+	var (
+		d   time.Duration
+		err error
+	)
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, ErrNegativeValue
+	}
+	return d, nil
+}
+
+// rotateLocation 为空时返回nil 使用本地时区
+func rotateLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, newConfigError("rotatelog.location", name, fmt.Errorf("%w: %s", ErrUnknownLocation, err.Error()))
 	}
-	return hook
+	return loc, nil
 }
@@ -0,0 +1,113 @@
+package rotatelogs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/crx666/xlog/common"
+
+	"github.com/pkg/errors"
+)
+
+const compressTempSuffix = ".tmp"
+
+// compressSem 限制同时压缩的文件数 所有RotateLogs共享
+var compressSem = make(chan struct{}, 2)
+
+// WithCompress creates a new Option that compresses every
+// file after it has been rotated, with gzip unless
+// WithCompressor is given. Compression runs in the
+// background, at most two files at a time per process.
+func WithCompress(b bool) Option {
+	return OptionFn(func(rl *RotateLogs) error {
+		rl.compress = b
+		return nil
+	})
+}
+
+// WithCompressor creates a new Option that sets the codec
+// used by WithCompress.
+func WithCompressor(c common.Compressor) Option {
+	return OptionFn(func(rl *RotateLogs) error {
+		rl.compressor = c
+		return nil
+	})
+}
+
+// compressFile 后台压缩切分后的文件 成功后删除原文件
+func (rl *RotateLogs) compressFile(path string) {
+	c := rl.compressor
+	if c == nil {
+		c, _ = common.NewCompressor(common.CompressGzip, 0)
+	}
+	go func() {
+		compressSem <- struct{}{}
+		defer func() { <-compressSem }()
+		if err := compressLogFile(c, path, path+c.Suffix()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compress %s: %s\n", path, err)
+			return
+		}
+		rl.emit(&FileCompressedEvent{name: path + c.Suffix()})
+	}()
+}
+
+// compressLogFile 先写到同目录的临时文件 完成后再改名为dst 不会留下不完整的压缩文件
+// 压缩期间原文件被清理时 同时删除压缩后的文件
+func compressLogFile(c common.Compressor, src, dst string) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, `failed to open log file`)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, `failed to stat log file`)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*"+compressTempSuffix)
+	if err != nil {
+		return errors.Wrap(err, `failed to create compressed log file`)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	cw, err := c.NewWriter(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(cw, f); err != nil {
+		return err
+	}
+	if err = cw.Close(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(fi.Mode()); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+
+	f.Close()
+	if err := os.Remove(src); err != nil {
+		if os.IsNotExist(err) {
+			os.Remove(dst)
+			return errors.New(`log file was removed during compression`)
+		}
+		return err
+	}
+	return nil
+}
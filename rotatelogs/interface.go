@@ -5,6 +5,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/crx666/xlog/common"
+
 	strftime "github.com/lestrrat/go-strftime"
 )
 
@@ -14,17 +16,23 @@ type RotateLogs struct {
 	clock         Clock
 	curFn         string
 	globPattern   string
+	nameTime      *nameTime
 	linkName      string
 	maxAge        time.Duration
+	maxTotalSize  int64
 	mutex         sync.RWMutex
 	outFh         *os.File
 	pattern       *strftime.Strftime
 	rotationTime  time.Duration
+	rotationOff   time.Duration
 	rotationCount int
 	closeChan     chan struct{}
 	temp          string
 	dir           string
 	close         bool
+	compress      bool
+	compressor    common.Compressor
+	eventHandler  Handler
 }
 
 // Clock is the interface used by the RotateLogs
@@ -34,6 +42,15 @@ type Clock interface {
 }
 type clockFn func() time.Time
 
+// AfterClock is a Clock that can also wait. When the clock
+// given to WithClock implements it (clockwork.FakeClock does),
+// the wait until the next rotation boundary is driven by the
+// clock as well, so rotation can be tested deterministically.
+type AfterClock interface {
+	Clock
+	After(d time.Duration) <-chan time.Time
+}
+
 // UTC is an object satisfying the Clock interface, which
 // returns the current time in UTC
 var UTC = clockFn(func() time.Time { return time.Now().UTC() })
@@ -51,3 +68,44 @@ type Option interface {
 // OptionFn is a type of Option that is represented
 // by a single function that gets called for Configure()
 type OptionFn func(*RotateLogs) error
+
+// Handler is called by RotateLogs when a file event happens,
+// such as a rotation or removal of an old file.
+type Handler interface {
+	Handle(Event)
+}
+
+// HandlerFunc is a Handler represented by a single function
+type HandlerFunc func(Event)
+
+type EventType int
+
+const (
+	InvalidEventType EventType = iota
+	FileRotatedEventType
+	FileDeletedEventType
+	FileCompressedEventType
+)
+
+// Event is passed to the Handler
+type Event interface {
+	Type() EventType
+}
+
+// FileRotatedEvent is emitted after the current file has been
+// switched to a new one
+type FileRotatedEvent struct {
+	prev    string
+	current string
+}
+
+// FileDeletedEvent is emitted after an old file has been removed
+type FileDeletedEvent struct {
+	name string
+}
+
+// FileCompressedEvent is emitted after a rotated file has been
+// compressed and the uncompressed file removed
+type FileCompressedEvent struct {
+	name string
+}
@@ -1,7 +1,8 @@
 package rotatelogs
 
 import (
-	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -11,33 +12,95 @@ import (
 
 func TestGenFilename(t *testing.T) {
 	// Mock time
-	ts := []time.Time{
-		time.Time{},
-		(time.Time{}).Add(24 * time.Hour),
+	xt := time.Date(2024, 3, 5, 7, 4, 9, 0, time.UTC)
+	cases := map[string]string{
+		"app_$day.temp":          "app_2024_03_05.temp",
+		"app_$hour.temp":         "app_2024_03_05_07.temp",
+		"app_$minute.temp":       "app_2024_03_05_07_04.temp",
+		"app_%Y-%m-%dT%H.log":    "app_2024-03-05T07.log",
+		"app_%F_%H%M%S.temp":     "app_2024-03-05_070409.temp",
+		"app_%b%d_%%.temp":       "app_Mar05_%.temp",
+		"app_%Y%m%d_$rand.temp":  "",
+		"$minute/app_%j.log":     "2024_03_05_07_04/app_065.log",
+		"app_%Y_%m_%d_$ip.temp":  "",
+		"app_%Y_%m_%d_$ti.temp":  "",
+		"app_%Y-%m-%d.log.temp":  "app_2024-03-05.log.temp",
+		"app_%y%m%d%H%M%S.temp":  "app_240305070409.temp",
+		"app_%e_%k_%l%p.temp":    "app_ 5_ 7_ 7AM.temp",
+		"app_%Y_%m_%d_%H.log":    "app_2024_03_05_07.log",
+		"app_%Y_%m_%d/%H.log":    "app_2024_03_05/07.log",
+		"app_$day_%H_%M_%S.temp": "app_2024_03_05_07_04_09.temp",
 	}
 
-	for _, xt := range ts {
-		rl, err := New(
-			"/path/to/%Y/%m/%d",
-			WithClock(clockwork.NewFakeClockAt(xt)),
-		)
+	dir := t.TempDir()
+	for pattern, expected := range cases {
+		rl, err := New(pattern, dir, WithClock(clockwork.NewFakeClockAt(xt)))
 		if !assert.NoError(t, err, "New should succeed") {
 			return
 		}
+		fn, err := rl.getFileName(dir)
+		if !assert.NoError(t, err) {
+			return
+		}
+		if expected != "" {
+			assert.Equal(t, filepath.Join(dir, expected), fn)
+		}
+		// 新生成的文件名可以被glob匹配并解析出时间
+		matched, _ := filepath.Match(rl.globPattern, fn)
+		assert.True(t, matched, "%s should match %s", fn, rl.globPattern)
+		if parsed, ok := rl.nameTime.parse(fn, time.UTC); ok {
+			assert.True(t, !parsed.After(xt) && xt.Sub(parsed) < 24*time.Hour, "%s parsed as %s", fn, parsed)
+		}
+		rl.Exit()
+	}
 
-		defer rl.Close()
+	_, err := New("app_%Q.temp", dir)
+	assert.Error(t, err, "unknown strftime format")
+}
 
-		fn := rl.genFilename()
-		expected := fmt.Sprintf("/path/to/%04d/%02d/%02d",
-			xt.Year(),
-			xt.Month(),
-			xt.Day(),
-		)
+func TestRotateOldNames(t *testing.T) {
+	dir := t.TempDir()
+	// 旧版本不补零的文件名和新文件名混在一起时 按名字中的时间排序保留最新的
+	names := []string{
+		"app_2024_3_9_23.log",
+		"app_2024_3_10_1.log",
+		"app_2024_03_10_02.log",
+		"app_2024_3_10_10.log",
+		"app_2024_03_10_11.log",
+	}
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		// 修改时间和名字中的时间相反 确保排序使用的是名字中的时间
+		mt := time.Now().Add(-time.Duration(i) * time.Hour)
+		os.Chtimes(path, mt, mt)
+	}
 
-		if !assert.Equal(t, expected, fn) {
-			return
+	xt := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+	rl, err := New("app_$hour.temp", dir, WithClock(clockwork.NewFakeClockAt(xt)), WithMaxAge(-1), WithRotationCount(3))
+	if !assert.NoError(t, err) {
+		return
+	}
+	if _, err := rl.Write([]byte("line\n")); !assert.NoError(t, err) {
+		return
+	}
+	defer rl.Exit()
+
+	expected := []string{"app_2024_03_10_11.log", "app_2024_03_10_12.temp", "app_2024_3_10_10.log"}
+	for i := 0; i < 50; i++ {
+		matches, _ := filepath.Glob(filepath.Join(dir, "app_*"))
+		if len(matches) == len(expected) {
+			break
 		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "app_*"))
+	for i := range matches {
+		matches[i] = filepath.Base(matches[i])
+	}
+	assert.Equal(t, expected, matches)
 }
 
 func TestWithLocation(t *testing.T) {
@@ -48,3 +111,35 @@ func TestWithLocation(t *testing.T) {
 	opt.Configure(&rl)
 	t.Logf("%s", rl.clock.Now())
 }
+
+func TestRotateSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	// 其他实例正在写的.temp和带其他$rand的文件都不能被清理
+	foreign := []string{
+		"app_2024_03_10_01.temp",
+		"app_zzzzz_2024_03_10_01.temp",
+		"app_zzzzz_2024_03_10_02.log",
+	}
+	for _, name := range foreign {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	xt := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+	for _, pattern := range []string{"app_$hour.temp", "app_$rand_$hour.temp"} {
+		rl, err := New(pattern, dir, WithClock(clockwork.NewFakeClockAt(xt)), WithMaxAge(-1), WithRotationCount(1))
+		if !assert.NoError(t, err) {
+			return
+		}
+		if _, err := rl.Write([]byte("line\n")); !assert.NoError(t, err) {
+			return
+		}
+		rl.Exit()
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, name := range foreign {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, "%s should not be purged", name)
+	}
+}
@@ -0,0 +1,220 @@
+package rotatelogs
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crx666/xlog/common"
+)
+
+// fileTemplate 文件名模板 每次生成都会变化的$ti换成* 其他占位符替换成strftime格式
+// $rand在New时已经固定 不会匹配到其他实例的文件
+// .temp结尾的临时文件切分后会改名为.log 所以后缀也换成* .log结尾的后面加上*匹配压缩后的文件
+func fileTemplate(temp string) string {
+	name := strings.ReplaceAll(temp, "$ti", "*")
+	name = common.ReplaceName(name)
+	if strings.HasSuffix(name, common.LogTemp) {
+		name = strings.TrimSuffix(name, common.LogTemp) + ".*"
+	} else if strings.HasSuffix(name, common.LogFormal) {
+		name += "*"
+	}
+	return name
+}
+
+// globFromTemplate strftime格式全部换成* 新旧文件名都能匹配
+func globFromTemplate(template string) string {
+	for _, re := range patternConversionRegexps {
+		template = re.ReplaceAllString(template, "*")
+	}
+	return template
+}
+
+// strftime中由其他格式组成的格式
+var compositeVerbs = map[byte]string{
+	'F': "%Y-%m-%d",
+	'T': "%H:%M:%S",
+	'X': "%H:%M:%S",
+	'R': "%H:%M",
+	'D': "%m/%d/%y",
+	'x': "%m/%d/%y",
+	'c': "%a %b %e %H:%M:%S %Y",
+	'v': "%e-%b-%Y",
+}
+
+// timeVerbs 各格式对应的正则 数字不要求补零 兼容旧版本生成的文件名
+var timeVerbs = map[byte]string{
+	'Y': `(\d{4})`,
+	'y': `(\d{2})`,
+	'm': `(\d{1,2})`,
+	'd': `(\d{1,2})`,
+	'e': `( ?\d{1,2})`,
+	'H': `(\d{1,2})`,
+	'k': `( ?\d{1,2})`,
+	'I': `(\d{1,2})`,
+	'l': `( ?\d{1,2})`,
+	'M': `(\d{1,2})`,
+	'S': `(\d{1,2})`,
+	'j': `(\d{1,3})`,
+	'b': `([A-Za-z]{3})`,
+	'h': `([A-Za-z]{3})`,
+	'B': `([A-Za-z]+)`,
+	'p': `([AP]M)`,
+	'A': `[A-Za-z]+`,
+	'a': `[A-Za-z]{3}`,
+	'C': `\d{2}`,
+	'U': `\d{1,2}`,
+	'V': `\d{1,2}`,
+	'W': `\d{1,2}`,
+	'u': `\d`,
+	'w': `\d`,
+	'Z': `[A-Za-z0-9+-]+`,
+	'z': `[+-]\d{4}`,
+	'n': `\n`,
+	't': `\t`,
+	'%': `%`,
+}
+
+// nameTime 从文件名中解析出生成时的时间
+type nameTime struct {
+	re    *regexp.Regexp
+	verbs []byte //每个分组对应的格式
+}
+
+// newNameTime template为fileTemplate生成的带目录的模板 不包含年份时无法解析返回nil
+func newNameTime(template string) *nameTime {
+	for verb, expand := range compositeVerbs {
+		template = strings.ReplaceAll(template, "%"+string(verb), expand)
+	}
+	var (
+		expr  strings.Builder
+		verbs []byte
+	)
+	expr.WriteByte('^')
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '*':
+			expr.WriteString(`.*?`)
+		case c == '%' && i+1 < len(template):
+			i++
+			re, ok := timeVerbs[template[i]]
+			if !ok {
+				return nil
+			}
+			expr.WriteString(re)
+			if strings.HasPrefix(re, "(") {
+				verbs = append(verbs, template[i])
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteByte('$')
+	if !strings.ContainsAny(string(verbs), "Yy") {
+		return nil
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil
+	}
+	return &nameTime{re: re, verbs: verbs}
+}
+
+// parse 没有匹配或者数值不合法时返回false
+func (n *nameTime) parse(path string, loc *time.Location) (time.Time, bool) {
+	if n == nil {
+		return time.Time{}, false
+	}
+	match := n.re.FindStringSubmatch(path)
+	if match == nil {
+		return time.Time{}, false
+	}
+	year, month, day, hour, min, sec, yday := 1970, 1, 1, 0, 0, 0, 0
+	pm, hour12 := false, false
+	for i, verb := range n.verbs {
+		value := strings.TrimSpace(match[i+1])
+		switch verb {
+		case 'b', 'h', 'B':
+			m, ok := parseMonth(value)
+			if !ok {
+				return time.Time{}, false
+			}
+			month = m
+			continue
+		case 'p':
+			pm = value == "PM"
+			continue
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		switch verb {
+		case 'Y':
+			year = v
+		case 'y':
+			year = 2000 + v
+			if v >= 69 { //同strptime 69-99为19xx
+				year = 1900 + v
+			}
+		case 'm':
+			month = v
+		case 'd', 'e':
+			day = v
+		case 'H', 'k':
+			hour = v
+		case 'I', 'l':
+			hour, hour12 = v%12, true
+		case 'M':
+			min = v
+		case 'S':
+			sec = v
+		case 'j':
+			yday = v
+		}
+	}
+	if hour12 && pm {
+		hour += 12
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || min > 59 || sec > 60 || yday > 366 {
+		return time.Time{}, false
+	}
+	if yday > 0 {
+		month, day = 1, yday
+	}
+	return time.Date(year, time.Month(month), day, hour, min, sec, 0, loc), true
+}
+
+func parseMonth(name string) (int, bool) {
+	for m := time.January; m <= time.December; m++ {
+		full := m.String()
+		if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+			return int(m), true
+		}
+	}
+	return 0, false
+}
+
+// sortByNameTime 按文件名中的时间从旧到新排序 无法解析时使用修改时间 返回每个文件使用的时间
+func sortByNameTime(paths []string, n *nameTime, loc *time.Location) map[string]time.Time {
+	times := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		if t, ok := n.parse(path, loc); ok {
+			times[path] = t
+		} else if fi, err := os.Stat(path); err == nil {
+			times[path] = fi.ModTime()
+		}
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		ti, tj := times[paths[i]], times[paths[j]]
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return paths[i] < paths[j]
+	})
+	return times
+}
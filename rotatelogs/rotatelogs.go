@@ -15,6 +15,7 @@ import (
 
 	"github.com/crx666/xlog/common"
 
+	strftime "github.com/lestrrat/go-strftime"
 	"github.com/pkg/errors"
 )
 
@@ -26,6 +27,58 @@ func (o OptionFn) Configure(rl *RotateLogs) error {
 	return o(rl)
 }
 
+func (h HandlerFunc) Handle(e Event) {
+	h(e)
+}
+
+func (e *FileRotatedEvent) Type() EventType {
+	return FileRotatedEventType
+}
+
+// PreviousFile returns the name of the file that was closed
+func (e *FileRotatedEvent) PreviousFile() string {
+	return e.prev
+}
+
+// CurrentFile returns the name of the file now being written to
+func (e *FileRotatedEvent) CurrentFile() string {
+	return e.current
+}
+
+func (e *FileDeletedEvent) Type() EventType {
+	return FileDeletedEventType
+}
+
+// File returns the name of the removed file
+func (e *FileDeletedEvent) File() string {
+	return e.name
+}
+
+func (e *FileCompressedEvent) Type() EventType {
+	return FileCompressedEventType
+}
+
+// File returns the name of the compressed file
+func (e *FileCompressedEvent) File() string {
+	return e.name
+}
+
+// WithHandler creates a new Option that specifies the
+// Handler object that gets invoked when an event occurs.
+// Currently FileRotated and FileDeleted events are supported.
+func WithHandler(h Handler) Option {
+	return OptionFn(func(rl *RotateLogs) error {
+		rl.eventHandler = h
+		return nil
+	})
+}
+
+func (rl *RotateLogs) emit(e Event) {
+	if rl.eventHandler != nil {
+		rl.eventHandler.Handle(e)
+	}
+}
+
 // WithClock creates a new Option that sets a clock
 // that the RotateLogs object will use to determine
 // the current time.
@@ -65,17 +118,30 @@ func WithLinkName(s string) Option {
 
 // WithMaxAge creates a new Option that sets the
 // max age of a log file before it gets purged from
-// the file system.
+// the file system. The age is taken from the time in
+// the file name, falling back to the modification time
+// when the name can not be parsed. Zero or negative
+// disables it.
 func WithMaxAge(d time.Duration) Option {
 	return OptionFn(func(rl *RotateLogs) error {
-		if rl.rotationCount > 0 && d > 0 {
-			return errors.New("attempt to set MaxAge when RotationCount is also given")
-		}
 		rl.maxAge = d
 		return nil
 	})
 }
 
+// WithMaxTotalSize creates a new Option that sets the
+// total size in bytes of all log files. The oldest files
+// are purged until the total fits. Zero disables it.
+func WithMaxTotalSize(n int64) Option {
+	return OptionFn(func(rl *RotateLogs) error {
+		if n < 0 {
+			return errors.New("max total size can not be negative")
+		}
+		rl.maxTotalSize = n
+		return nil
+	})
+}
+
 // WithRotationTime creates a new Option that sets the
 // time between rotation.
 func WithRotationTime(d time.Duration) Option {
@@ -85,14 +151,28 @@ func WithRotationTime(d time.Duration) Option {
 	})
 }
 
+// WithRotationOffset creates a new Option that shifts the
+// rotation boundaries. Boundaries are aligned to the wall clock
+// of the clock's location (top of the minute, hour or day), so
+// an offset of 4 hours with a rotation time of 24 hours rotates
+// every day at 04:00. The offset is taken modulo the rotation time.
+func WithRotationOffset(d time.Duration) Option {
+	return OptionFn(func(rl *RotateLogs) error {
+		if d < 0 {
+			return errors.New("rotation offset can not be negative")
+		}
+		rl.rotationOff = d
+		return nil
+	})
+}
+
 // WithRotationCount creates a new Option that sets the
 // number of files should be kept before it gets
-// purged from the file system.
+// purged from the file system. It can be combined with
+// WithMaxAge and WithMaxTotalSize, a file is purged as
+// soon as any of them is exceeded.
 func WithRotationCount(n int) Option {
 	return OptionFn(func(rl *RotateLogs) error {
-		if rl.maxAge > 0 && n > 0 {
-			return errors.New("attempt to set RotationCount when MaxAge is also given")
-		}
 		rl.rotationCount = n
 		return nil
 	})
@@ -113,19 +193,30 @@ func New(pattern, dir string, options ...Option) (*RotateLogs, error) {
 	//	return nil, errors.Wrap(err, `invalid strftime pattern`)
 	//}
 
+	if _, err := strftime.New(common.ReplaceName(pattern)); err != nil {
+		return nil, errors.Wrap(err, `invalid strftime pattern`)
+	}
+
 	var rl RotateLogs
 	rl.clock = Local
 	//rl.globPattern = globPattern
 	//rl.pattern = strfobj
 	rl.rotationTime = 24 * time.Hour
-	// Keeping forward compatibility, maxAge is prior to rotationCount.
 	rl.maxAge = 7 * 24 * time.Hour
 	rl.rotationCount = -1
 	rl.closeChan = make(chan struct{})
-	rl.temp = pattern
+	// $rand在整个实例中保持不变 清理时只匹配本实例的文件 不会误删其他实例的日志
+	rl.temp = strings.ReplaceAll(pattern, "$rand", common.ReplaceName("$rand"))
 	rl.dir = dir
 	for _, opt := range options {
-		opt.Configure(&rl)
+		if err := opt.Configure(&rl); err != nil {
+			return nil, err
+		}
+	}
+	// 启动时先清理一次旧文件
+	if dir, err := rl.getFileDir(); err == nil {
+		rl.updatePattern(dir)
+		rl.purge("")
 	}
 	go rl.changeLogName()
 	return &rl, nil
@@ -142,27 +233,21 @@ func (rl *RotateLogs) getFileDir() (string, error) {
 	return dir, nil
 }
 
-func (rl *RotateLogs) getFileName(dir string) string {
-	now := rl.clock.Now()
-	name := common.ReplaceName(rl.temp)
-	fileName := filepath.Join(dir, name)
-	globPattern := fileName
-	for _, re := range patternConversionRegexps {
-		globPattern = re.ReplaceAllString(globPattern, "*")
-	}
-	rl.globPattern = globPattern
-	for strings.Contains(fileName, "%") {
-		if strings.Contains(fileName, "%Y_%m_%d") {
-			fileName = strings.ReplaceAll(fileName, "%Y_%m_%d", fmt.Sprintf("%d_%d_%d", now.Year(), now.Month(), now.Day()))
-		} else if strings.Contains(fileName, "%H") {
-			fileName = strings.ReplaceAll(fileName, "%H", fmt.Sprintf("%d", now.Hour()))
-		} else if strings.Contains(fileName, "%M") {
-			fileName = strings.ReplaceAll(fileName, "%M", fmt.Sprintf("%d", now.Minute()))
-		} else {
-			break
-		}
+// updatePattern 更新清理旧文件用的glob和时间解析
+func (rl *RotateLogs) updatePattern(dir string) {
+	template := filepath.Join(dir, fileTemplate(rl.temp))
+	rl.globPattern = globFromTemplate(template)
+	rl.nameTime = newNameTime(template)
+}
+
+// getFileName 按strftime格式生成文件名
+func (rl *RotateLogs) getFileName(dir string) (string, error) {
+	rl.updatePattern(dir)
+	name, err := strftime.Format(common.ReplaceName(rl.temp), rl.clock.Now())
+	if err != nil {
+		return "", errors.Wrap(err, `invalid strftime pattern`)
 	}
-	return fileName
+	return filepath.Join(dir, name), nil
 }
 
 //func (rl *RotateLogs) genFilename() string {
@@ -172,25 +257,75 @@ func (rl *RotateLogs) getFileName(dir string) string {
 //	return rl.pattern.FormatString(t)
 //}
 
+// nextRotation returns the first rotation boundary after now.
+// Boundaries are aligned to the wall clock in now's location:
+// periods of whole days start at local midnight, counted from
+// 1970-01-01, shorter periods start again at every local midnight.
+// offset shifts every boundary and must be less than period.
+func nextRotation(now time.Time, period, offset time.Duration) time.Time {
+	const day = 24 * time.Hour
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if period%day == 0 {
+		days := int(period / day)
+		epochDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Unix() / int64(day/time.Second)
+		start := midnight.AddDate(0, 0, -int(epochDay%int64(days)))
+		if now.Before(start.Add(offset)) {
+			start = start.AddDate(0, 0, -days)
+		}
+		return start.AddDate(0, 0, days).Add(offset)
+	}
+	start := midnight
+	if now.Before(start.Add(offset)) {
+		start = start.AddDate(0, 0, -1)
+	}
+	next := start.Add(offset + (now.Sub(start.Add(offset))/period+1)*period)
+	// 不能整除一天时 每天0点重新对齐
+	if limit := start.AddDate(0, 0, 1).Add(offset); next.After(limit) {
+		next = limit
+	}
+	return next
+}
+
+// after waits d on the clock when it is an AfterClock
+func (rl *RotateLogs) after(d time.Duration) (<-chan time.Time, func()) {
+	if c, ok := rl.clock.(AfterClock); ok {
+		return c.After(d), func() {}
+	}
+	t := time.NewTimer(d)
+	return t.C, func() { t.Stop() }
+}
+
 func (rl *RotateLogs) changeLogName() {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Fprintf(os.Stderr, "RotateLogs changeLogName error:%s", r)
 		}
 	}()
-	ticker := time.NewTicker(rl.rotationTime)
-	go func() {
-		// 关闭，则关闭对应的ticker
+	if rl.rotationTime <= 0 {
 		<-rl.closeChan
-		rl.close = true //关闭写逻辑
-		ticker.Stop()
-	}()
-
-	for range ticker.C {
+		rl.mutex.Lock()
+		rl.close = true
+		rl.mutex.Unlock()
+		return
+	}
+	for {
+		now := rl.clock.Now()
+		wait, stop := rl.after(nextRotation(now, rl.rotationTime, rl.rotationOff%rl.rotationTime).Sub(now))
+		select {
+		case <-rl.closeChan:
+			// 关闭，则停止切分并关闭写逻辑
+			stop()
+			rl.mutex.Lock()
+			rl.close = true
+			rl.mutex.Unlock()
+			return
+		case <-wait:
+		}
+		rl.mutex.Lock()
 		err := rl.changeLog()
+		rl.mutex.Unlock()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, err.Error())
-			continue
+			fmt.Fprintln(os.Stderr, err.Error())
 		}
 	}
 }
@@ -200,13 +335,26 @@ func (rl *RotateLogs) changeLog() error {
 	if err != nil {
 		return errors.Errorf("failed to get dir.%s", err.Error())
 	}
-	filename := rl.getFileName(dir)
+	filename, err := rl.getFileName(dir)
+	if err != nil {
+		return err
+	}
 	// if we got here, then we need to create a file
 	fh, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
 		return errors.Errorf("failed to open file %s: %s", filename, err)
 	}
 
+	// 先把旧文件改成.log 清理时才会计入
+	prev := rl.curFn
+	if prev != "" { //代表是替换文件不是创建文件
+		prev, err = common.RenameLogFile(prev)
+		if err != nil {
+			fh.Close()
+			return errors.Errorf("failed to rename file %s", err)
+		}
+	}
+
 	if err := rl.rotate(filename); err != nil {
 		// Failure to rotate is a problem, but it's really not a great
 		// idea to stop your application just because you couldn't rename
@@ -214,18 +362,18 @@ func (rl *RotateLogs) changeLog() error {
 		// os.Stderr
 		fmt.Fprintf(os.Stderr, "failed to rotate: %s\n", err)
 	}
-	if rl.curFn != "" { //代表是替换文件不是创建文件
-		err = common.ReplaceLogName(rl.curFn)
-		if err != nil {
-			return errors.Errorf("failed to rename file %s", err)
-		}
-	}
 
 	if rl.outFh != nil {
 		rl.outFh.Close()
 	}
 	rl.outFh = fh
 	rl.curFn = filename
+	if prev != "" {
+		rl.emit(&FileRotatedEvent{prev: prev, current: filename})
+	}
+	if rl.compress && prev != "" && prev != filename {
+		rl.compressFile(prev)
+	}
 	return nil
 }
 
@@ -349,65 +497,100 @@ func (rl *RotateLogs) rotate(filename string) error {
 		}
 	}
 
-	if rl.maxAge <= 0 && rl.rotationCount <= 0 {
-		return errors.New("panic: maxAge and rotationCount are both set")
-	}
+	rl.purge(filename)
+	return nil
+}
 
+// purge 按文件名中的时间从旧到新 删除超过maxAge的 超出rotationCount份的
+// 以及总大小超过maxTotalSize时最旧的文件 current为正在写的文件不会被删除
+func (rl *RotateLogs) purge(current string) {
+	if rl.maxAge <= 0 && rl.rotationCount <= 0 && rl.maxTotalSize <= 0 {
+		return
+	}
 	matches, err := filepath.Glob(rl.globPattern)
 	if err != nil {
-		return err
+		return
 	}
+	now := rl.clock.Now()
+	times := sortByNameTime(matches, rl.nameTime, now.Location())
 
-	cutoff := rl.clock.Now().Add(-1 * rl.maxAge)
-	var toUnlink []string
+	compressed := make(map[string]bool)
 	for _, path := range matches {
-		// Ignore lock files
-		if strings.HasSuffix(path, "_lock") || strings.HasSuffix(path, "_symlink") {
+		if src, ok := common.TrimCompressSuffix(path); ok {
+			compressed[src] = true
+		}
+	}
+	var files []string
+	for _, path := range matches {
+		// Ignore lock files and compressing temp files
+		if strings.HasSuffix(path, "_lock") || strings.HasSuffix(path, "_symlink") || strings.HasSuffix(path, compressTempSuffix) {
 			continue
 		}
-
-		fi, err := os.Stat(path)
-		if err != nil {
+		// 其他.temp是正在写的文件 可能属于共用目录的其他实例 只清理切分后的文件
+		if path != current && strings.Contains(filepath.Base(path), common.LogTemp) {
 			continue
 		}
-
-		fl, err := os.Lstat(path)
-		if err != nil {
+		// 压缩完成还没删除原文件时只计算压缩后的文件
+		if compressed[path] {
 			continue
 		}
-
-		if rl.maxAge > 0 && fi.ModTime().After(cutoff) {
+		fl, err := os.Lstat(path)
+		if err != nil || fl.Mode()&os.ModeSymlink == os.ModeSymlink {
 			continue
 		}
+		files = append(files, path)
+	}
 
-		if rl.rotationCount > 0 && fl.Mode()&os.ModeSymlink == os.ModeSymlink {
+	var toUnlink, kept []string
+	cutoff := now.Add(-1 * rl.maxAge)
+	for _, path := range files {
+		if rl.maxAge > 0 && path != current && times[path].Before(cutoff) {
+			toUnlink = append(toUnlink, path)
 			continue
 		}
-		toUnlink = append(toUnlink, path)
+		kept = append(kept, path)
 	}
-
-	if rl.rotationCount > 0 {
+	if rl.rotationCount > 0 && len(kept) > rl.rotationCount {
 		// Only delete if we have more than rotationCount
-		if rl.rotationCount >= len(toUnlink) {
-			return nil
+		remain := kept[len(kept)-rl.rotationCount:]
+		for _, path := range kept[:len(kept)-rl.rotationCount] {
+			if path == current {
+				remain = append(remain, path)
+				continue
+			}
+			toUnlink = append(toUnlink, path)
+		}
+		kept = remain
+	}
+	if rl.maxTotalSize > 0 {
+		var total int64
+		sizes := make([]int64, len(kept))
+		for i, path := range kept {
+			if fi, err := os.Stat(path); err == nil {
+				sizes[i] = fi.Size()
+				total += sizes[i]
+			}
+		}
+		for i := 0; i < len(kept) && total > rl.maxTotalSize; i++ {
+			if kept[i] == current {
+				continue
+			}
+			toUnlink = append(toUnlink, kept[i])
+			total -= sizes[i]
 		}
-
-		toUnlink = toUnlink[:len(toUnlink)-rl.rotationCount]
 	}
 
 	if len(toUnlink) <= 0 {
-		return nil
+		return
 	}
-
-	guard.Enable()
 	go func() {
 		// unlink files on a separate goroutine
 		for _, path := range toUnlink {
-			os.Remove(path)
+			if os.Remove(path) == nil {
+				rl.emit(&FileDeletedEvent{name: path})
+			}
 		}
 	}()
-
-	return nil
 }
 
 // Close satisfies the io.Closer interface. You must
@@ -426,11 +609,26 @@ func (rl *RotateLogs) Close() error {
 	return nil
 }
 
+// Flush syncs the current file to disk and renames it from
+// .temp to .log, keeping it open for further writes.
+func (rl *RotateLogs) Flush() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.outFh == nil {
+		return
+	}
+	rl.outFh.Sync()
+	if name, err := common.RenameLogFile(rl.curFn); err == nil {
+		rl.curFn = name
+	}
+}
+
 func (rl *RotateLogs) Exit() error {
 	if rl.outFh == nil {
 		return nil
 	}
-	name := rl.outFh.Name()
+	name := rl.CurrentFileName()
 	err := rl.Close()
 	if err != nil {
 		return err
@@ -440,16 +638,13 @@ func (rl *RotateLogs) Exit() error {
 	return err
 }
 
+// Sync commits the current file to disk. Use Exit to close it.
 func (rl *RotateLogs) Sync() error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
 	if rl.outFh == nil {
 		return nil
 	}
-	name := rl.outFh.Name()
-	err := rl.Close()
-	if err != nil {
-		return err
-	}
-	rl.closeChan <- struct{}{}
-	err = common.ReplaceLogName(name)
-	return err
+	return rl.outFh.Sync()
 }
@@ -0,0 +1,211 @@
+package xlog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crx666/xlog/config"
+)
+
+const DefaultSampleInterval = time.Second
+
+type sampleKey struct {
+	level int
+	msg   string
+}
+
+type sampleCount struct {
+	count      int64
+	suppressed int64
+}
+
+type sampleSummary struct {
+	level      int
+	msg        string
+	suppressed int64
+	limited    bool //被限流丢弃
+}
+
+// sampler 按等级和内容采样 再按令牌桶限流 由派生的子logger共享
+// 每个周期内有日志被丢弃时 周期结束时打印一条汇总
+// F和W系列以format为内容 其他以拼接后的内容为准 panic和fatal不受影响
+type sampler struct {
+	mu         sync.Mutex
+	active     int32
+	interval   time.Duration
+	first      int64
+	thereafter int64
+	rate       float64
+	burst      float64
+	tokens     float64
+	last       time.Time //上次补充令牌的时间
+	window     int64     //当前周期编号 定时器按编号判断周期是否已经结束
+	start      time.Time
+	counts     map[sampleKey]*sampleCount
+	limited    map[int]int64
+	timer      *time.Timer
+	enabled    func(level int) bool //等级未开启的日志不参与采样
+	emit       func(level int, msg string, fields ...LogField)
+}
+
+func newSampler(enabled func(level int) bool, emit func(level int, msg string, fields ...LogField)) *sampler {
+	return &sampler{
+		enabled: enabled,
+		emit:    emit,
+		counts:  make(map[sampleKey]*sampleCount),
+		limited: make(map[int]int64),
+	}
+}
+
+// apply 应用新的采样配置 未打印的汇总先打印
+func (s *sampler) apply(cfg *config.Sampling) {
+	now := time.Now()
+	s.mu.Lock()
+	summaries := s.rollLocked(now)
+	s.first, s.thereafter, s.rate, s.burst = 0, 0, 0, 0
+	s.interval = DefaultSampleInterval
+	if cfg != nil {
+		s.first, s.thereafter = int64(cfg.First), int64(cfg.Thereafter)
+		s.rate, s.burst = float64(cfg.RateLimit), float64(cfg.Burst)
+		if cfg.Interval > 0 {
+			s.interval = time.Duration(cfg.Interval) * time.Millisecond
+		}
+		if s.burst <= 0 {
+			s.burst = s.rate
+		}
+	}
+	s.tokens, s.last = s.burst, now
+	atomic.StoreInt32(&s.active, boolToInt32(s.first > 0 || s.rate > 0))
+	s.mu.Unlock()
+	s.flush(summaries)
+}
+
+// allow 是否打印 format为空时用v拼接后的内容作为key 只在开启采样时拼接
+func (s *sampler) allow(level int, format string, v []interface{}) bool {
+	if s == nil || atomic.LoadInt32(&s.active) == 0 || level >= PanicLevel {
+		return true
+	}
+	if s.enabled != nil && !s.enabled(level) {
+		return true
+	}
+	key := format
+	if v != nil {
+		key = fmt.Sprint(v...)
+	}
+	now := time.Now()
+	s.mu.Lock()
+	var summaries []sampleSummary
+	if now.Sub(s.start) >= s.interval {
+		summaries = s.rollLocked(now)
+	}
+	ok := s.sampleLocked(level, key) && s.takeLocked(level, now)
+	if !ok && s.timer == nil {
+		window := s.window
+		s.timer = time.AfterFunc(s.start.Add(s.interval).Sub(now), func() {
+			s.closeWindow(window)
+		})
+	}
+	s.mu.Unlock()
+	s.flush(summaries)
+	return ok
+}
+
+func (s *sampler) sampleLocked(level int, key string) bool {
+	if s.first <= 0 {
+		return true
+	}
+	k := sampleKey{level: level, msg: key}
+	c := s.counts[k]
+	if c == nil {
+		c = new(sampleCount)
+		s.counts[k] = c
+	}
+	c.count++
+	if c.count <= s.first {
+		return true
+	}
+	if s.thereafter > 0 && (c.count-s.first)%s.thereafter == 0 {
+		return true
+	}
+	c.suppressed++
+	return false
+}
+
+// takeLocked 令牌桶 按rate补充令牌 最多burst个
+func (s *sampler) takeLocked(level int, now time.Time) bool {
+	if s.rate <= 0 {
+		return true
+	}
+	s.tokens += now.Sub(s.last).Seconds() * s.rate
+	if s.tokens > s.burst {
+		s.tokens = s.burst
+	}
+	s.last = now
+	if s.tokens < 1 {
+		s.limited[level]++
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+func (s *sampler) closeWindow(window int64) {
+	s.mu.Lock()
+	if s.window != window {
+		s.mu.Unlock()
+		return
+	}
+	summaries := s.rollLocked(time.Now())
+	s.mu.Unlock()
+	s.flush(summaries)
+}
+
+// rollLocked 结束当前周期 返回需要打印的汇总
+func (s *sampler) rollLocked(now time.Time) []sampleSummary {
+	var summaries []sampleSummary
+	for k, c := range s.counts {
+		if c.suppressed > 0 {
+			summaries = append(summaries, sampleSummary{level: k.level, msg: k.msg, suppressed: c.suppressed})
+		}
+	}
+	for level, n := range s.limited {
+		summaries = append(summaries, sampleSummary{level: level, suppressed: n, limited: true})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].level != summaries[j].level {
+			return summaries[i].level < summaries[j].level
+		}
+		return summaries[i].msg < summaries[j].msg
+	})
+	if len(s.counts) > 0 {
+		s.counts = make(map[sampleKey]*sampleCount)
+	}
+	if len(s.limited) > 0 {
+		s.limited = make(map[int]int64)
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.window++
+	s.start = now
+	return summaries
+}
+
+func (s *sampler) flush(summaries []sampleSummary) {
+	if s.emit == nil {
+		return
+	}
+	for _, summary := range summaries {
+		if summary.limited {
+			s.emit(summary.level, fmt.Sprintf("suppressed %d messages by rate limit", summary.suppressed),
+				Field("suppressed", summary.suppressed))
+			continue
+		}
+		s.emit(summary.level, fmt.Sprintf("suppressed %d similar messages", summary.suppressed),
+			Field("sampled_message", summary.msg), Field("suppressed", summary.suppressed))
+	}
+}
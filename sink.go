@@ -0,0 +1,391 @@
+package xlog
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/crx666/xlog/config"
+	"github.com/crx666/xlog/lumberjack"
+	"github.com/crx666/xlog/rotatelogs"
+)
+
+// swapLogFile 可在运行时替换底层文件的LogFileWrite 没有文件时丢弃写入
+// 替换时会等待正在进行的写入完成 再关闭旧文件并把.temp改成.log
+type swapLogFile struct {
+	mu   sync.RWMutex
+	file LogFileWrite
+}
+
+func (s *swapLogFile) Write(p []byte) (n int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.file == nil {
+		return len(p), nil
+	}
+	return s.file.Write(p)
+}
+
+func (s *swapLogFile) WriteLevel(level int, p []byte) (n int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.file == nil {
+		return len(p), nil
+	}
+	return writeLevel(s.file, level, p)
+}
+
+func (s *swapLogFile) enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.file != nil
+}
+
+// swap 先打开新文件 成功后再替换并关闭旧文件 打开失败时保留旧文件
+// reopen不为空表示新旧文件同名 两个句柄会互相改名 只能先关闭旧文件 打开失败时用reopen恢复旧文件
+func (s *swapLogFile) swap(open, reopen func() (LogFileWrite, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reopen == nil || s.file == nil {
+		file, err := open()
+		if err != nil {
+			return err
+		}
+		old := s.file
+		s.file = file
+		if old == nil {
+			return nil
+		}
+		return old.Exit()
+	}
+	err := s.file.Exit()
+	s.file = nil
+	file, openErr := open()
+	if openErr != nil {
+		if file, err := reopen(); err == nil {
+			s.file = file
+		}
+		return openErr
+	}
+	s.file = file
+	return err
+}
+
+func (s *swapLogFile) Exit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Exit()
+	s.file = nil
+	return err
+}
+
+// Sync 同步当前文件到磁盘 zap在打印panic等日志和Sync时调用 不能关闭文件
+func (s *swapLogFile) Sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return syncLogFile(s.file)
+}
+
+// Flush 刷新当前文件 文件保持打开
+func (s *swapLogFile) Flush() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flushLogFile(s.file)
+}
+
+// logFileFlusher 可以在不关闭的情况下刷新的LogFileWrite
+// 文件会同步到磁盘并把.temp改成.log 网络输出会发送缓冲中的日志
+type logFileFlusher interface {
+	Flush()
+}
+
+// LevelWriter 写入时同时传入日志等级的输出 各后端写文件时都会带上等级
+// 异步写按等级丢弃和http发送时使用 没有等级的Write从内容中识别
+type LevelWriter interface {
+	WriteLevel(level int, p []byte) (n int, err error)
+}
+
+// writeLevel w实现了LevelWriter时带上等级写入
+func writeLevel(w io.Writer, level int, p []byte) (int, error) {
+	if lw, ok := w.(LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
+
+// leveledWriter 固定等级的输出 用于只接收io.Writer的编码和钩子
+type leveledWriter struct {
+	w     io.Writer
+	level int
+}
+
+func (l leveledWriter) Write(p []byte) (n int, err error) {
+	return writeLevel(l.w, l.level, p)
+}
+
+// logFileSyncer 可以同步到磁盘的LogFileWrite
+type logFileSyncer interface {
+	Sync() error
+}
+
+// syncLogFile file没有实现Sync时什么也不做
+func syncLogFile(file LogFileWrite) error {
+	if f, ok := file.(logFileSyncer); ok {
+		return f.Sync()
+	}
+	return nil
+}
+
+// flushLogFile file没有实现Flush时什么也不做
+func flushLogFile(file LogFileWrite) {
+	if f, ok := file.(logFileFlusher); ok {
+		f.Flush()
+	}
+}
+
+// logSinks 日志文件输出 由同一个writer派生的子logger共享
+type logSinks struct {
+	mu       sync.Mutex
+	cfg      config.LogConfig
+	console  int32
+	splitErr int32
+	info     *swapLogFile
+	err      *swapLogFile
+	metrics  *WriterMetrics
+}
+
+func newLogSinks(metrics *WriterMetrics) *logSinks {
+	return &logSinks{
+		info:    new(swapLogFile),
+		err:     new(swapLogFile),
+		metrics: metrics,
+	}
+}
+
+func (s *logSinks) isConsole() bool {
+	return atomic.LoadInt32(&s.console) == 1
+}
+
+func (s *logSinks) isSplitErr() bool {
+	return atomic.LoadInt32(&s.splitErr) == 1
+}
+
+// apply 应用配置 只有文件相关配置变化时才替换文件
+func (s *logSinks) apply(cfg *config.LogConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	atomic.StoreInt32(&s.console, boolToInt32(cfg.IsConsole))
+	changed := !sameLogFileConfig(&s.cfg, cfg) || !s.info.enabled()
+	old := s.cfg
+	s.cfg = *cfg
+	if !changed {
+		return nil
+	}
+	if cfg.LogDir == "" || cfg.LogName == "" {
+		atomic.StoreInt32(&s.splitErr, 0)
+		return firstErr(s.info.Exit(), s.err.Exit())
+	}
+	err := s.info.swap(s.opener(cfg, cfg.LogName), s.reopener(&old, old.LogName, cfg, cfg.LogName))
+	if err != nil {
+		s.cfg = old
+		return err
+	}
+	if cfg.ErrLogName == "" {
+		atomic.StoreInt32(&s.splitErr, 0)
+		return s.err.Exit()
+	}
+	if !s.err.enabled() {
+		old.ErrLogName = ""
+	}
+	err = s.err.swap(s.opener(cfg, cfg.ErrLogName), s.reopener(&old, old.ErrLogName, cfg, cfg.ErrLogName))
+	if err != nil {
+		s.cfg.ErrLogName = old.ErrLogName
+	}
+	atomic.StoreInt32(&s.splitErr, boolToInt32(s.err.enabled()))
+	return err
+}
+
+func (s *logSinks) opener(cfg *config.LogConfig, name string) func() (LogFileWrite, error) {
+	return func() (LogFileWrite, error) {
+		return newLogFileWrite(cfg, name, s.metrics)
+	}
+}
+
+// reopener 新旧文件同名时返回按旧配置重新打开的函数 不同名时返回nil 带$rand的每次打开都不同名
+func (s *logSinks) reopener(old *config.LogConfig, oldName string, cfg *config.LogConfig, name string) func() (LogFileWrite, error) {
+	if oldName == "" || old.LogDir != cfg.LogDir || oldName != name ||
+		strings.Contains(cfg.LogDir, "$rand") || strings.Contains(name, "$rand") {
+		return nil
+	}
+	return s.opener(old, oldName)
+}
+
+// flush 刷新所有文件 不关闭 panic被recover后还可以继续写入
+func (s *logSinks) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info.Flush()
+	s.err.Flush()
+}
+
+func (s *logSinks) exit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return firstErr(s.info.Exit(), s.err.Exit())
+}
+
+// errWriter 错误日志输出 没有配置错误日志文件时写到正常日志文件
+func (s *logSinks) errWriter() LogFileWrite {
+	return errSink{s}
+}
+
+type errSink struct {
+	sinks *logSinks
+}
+
+func (e errSink) Write(p []byte) (n int, err error) {
+	if e.sinks.isSplitErr() {
+		return e.sinks.err.Write(p)
+	}
+	return e.sinks.info.Write(p)
+}
+
+func (e errSink) WriteLevel(level int, p []byte) (n int, err error) {
+	if e.sinks.isSplitErr() {
+		return e.sinks.err.WriteLevel(level, p)
+	}
+	return e.sinks.info.WriteLevel(level, p)
+}
+
+func (e errSink) Exit() error {
+	return nil
+}
+
+// consoleWriter 控制台输出 is_console关闭时丢弃
+func (s *logSinks) consoleWriter(out io.Writer) io.Writer {
+	return consoleSink{sinks: s, out: out}
+}
+
+type consoleSink struct {
+	sinks *logSinks
+	out   io.Writer
+}
+
+func (c consoleSink) Write(p []byte) (n int, err error) {
+	if c.sinks.isConsole() {
+		c.out.Write(p)
+	}
+	return len(p), nil
+}
+
+// fileWriter 同时写控制台和文件 std后端使用 写文件时带上等级
+func (s *logSinks) fileWriter(console io.Writer, file LogFileWrite) io.Writer {
+	return fileSink{console: s.consoleWriter(console), file: file}
+}
+
+type fileSink struct {
+	console io.Writer
+	file    LogFileWrite
+}
+
+func (f fileSink) Write(p []byte) (n int, err error) {
+	f.console.Write(p)
+	return f.file.Write(p)
+}
+
+func (f fileSink) WriteLevel(level int, p []byte) (n int, err error) {
+	f.console.Write(p)
+	return writeLevel(f.file, level, p)
+}
+
+func sameLogFileConfig(a, b *config.LogConfig) bool {
+	return a.LogDir == b.LogDir &&
+		a.LogName == b.LogName &&
+		a.ErrLogName == b.ErrLogName &&
+		reflect.DeepEqual(a.Rotatelog, b.Rotatelog) &&
+		reflect.DeepEqual(a.Lumberjack, b.Lumberjack) &&
+		reflect.DeepEqual(a.Compression, b.Compression) &&
+		reflect.DeepEqual(a.Network, b.Network) &&
+		reflect.DeepEqual(a.Syslog, b.Syslog) &&
+		reflect.DeepEqual(a.Http, b.Http) &&
+		reflect.DeepEqual(a.Async, b.Async)
+}
+
+// newLogFileWrite 根据配置创建日志文件 配置了async时包装成异步写 metrics不为空时统计写入和切分
+func newLogFileWrite(cfg *config.LogConfig, name string, metrics *WriterMetrics) (LogFileWrite, error) {
+	file, err := openLogFileWrite(cfg, name, metrics)
+	if err != nil {
+		return nil, err
+	}
+	if metrics != nil {
+		file = metricsFile{LogFileWrite: file, metrics: metrics}
+	}
+	if cfg.Async != nil {
+		return NewAsyncLogFile(file, cfg.Async), nil
+	}
+	return file, nil
+}
+
+// openLogFileWrite 配置了http syslog或network时通过网络发送 否则rotatelog优先 其次lumberjack 都没有则创建普通文件
+func openLogFileWrite(cfg *config.LogConfig, name string, metrics *WriterMetrics) (LogFileWrite, error) {
+	if cfg.Http != nil {
+		return NewHttpLogWriter(cfg.LogDir, name, cfg.Http)
+	}
+	if cfg.Syslog != nil {
+		return NewSyslogLogWriter(cfg.Syslog)
+	}
+	if cfg.Network != nil {
+		return NewNetworkLogWriter(cfg.LogDir, name, cfg.Network)
+	}
+	compressor, err := newCompressor(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Rotatelog != nil {
+		var opts []rotatelogs.Option
+		if metrics != nil {
+			opts = append(opts, rotatelogs.WithHandler(metrics.rotateHandler()))
+		}
+		if compressor != nil {
+			opts = append(opts, rotatelogs.WithCompressor(compressor))
+		}
+		return newRotateLogWriter(cfg.LogDir, name, cfg.Rotatelog, opts...)
+	}
+	if cfg.Lumberjack != nil {
+		file, err := NewLumberjackLogWriter(cfg.LogDir, name, cfg.Lumberjack)
+		if err == nil && metrics != nil {
+			file.(*lumberjack.Logger).SetHandler(metrics.lumberjackHandler)
+		}
+		if err == nil && compressor != nil {
+			file.(*lumberjack.Logger).SetCompressor(compressor)
+		}
+		return file, err
+	}
+	file, err := NewNormalLogFile(cfg.LogDir, name)
+	if err != nil {
+		return nil, newConfigError("log_name", name, fmt.Errorf("%w: %s", ErrOpenLogFile, err.Error()))
+	}
+	return file, nil
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
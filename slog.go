@@ -0,0 +1,478 @@
+//go:build go1.21
+
+package xlog
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	SlogLevelPanic = slog.LevelError + 4
+	SlogLevelFatal = slog.LevelError + 8
+)
+
+var slogLevels = map[int]slog.Level{
+	DebugLevel: slog.LevelDebug,
+	InfoLevel:  slog.LevelInfo,
+	WarnLevel:  slog.LevelWarn,
+	ErrorLevel: slog.LevelError,
+	PanicLevel: SlogLevelPanic,
+	FatalLevel: SlogLevelFatal,
+}
+
+// ToSlogLevel xlog等级转slog等级
+func ToSlogLevel(level int) slog.Level {
+	if lv, ok := slogLevels[level]; ok {
+		return lv
+	}
+	return slog.LevelDebug
+}
+
+// FromSlogLevel slog等级转xlog等级 自定义等级向下取最近的等级
+func FromSlogLevel(level slog.Level) int {
+	switch {
+	case level >= SlogLevelFatal:
+		return FatalLevel
+	case level >= SlogLevelPanic:
+		return PanicLevel
+	case level >= slog.LevelError:
+		return ErrorLevel
+	case level >= slog.LevelWarn:
+		return WarnLevel
+	case level >= slog.LevelInfo:
+		return InfoLevel
+	default:
+		return DebugLevel
+	}
+}
+
+// SlogHandler 把slog的记录转发给任意xlog Writer
+type SlogHandler struct {
+	writer Writer
+	level  slog.Leveler //为空时使用writer自身的等级
+	fields []LogField
+	prefix string //WithGroup生成的key前缀
+}
+
+var _ slog.Handler = (*SlogHandler)(nil)
+
+func NewSlogHandler(w Writer, level slog.Leveler) *SlogHandler {
+	return &SlogHandler{
+		writer: w,
+		level:  level,
+	}
+}
+
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if h.level != nil {
+		return level >= h.level.Level()
+	}
+	return level >= ToSlogLevel(h.writer.GetLevel())
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make([]LogField, 0, len(h.fields)+r.NumAttrs())
+	fields = append(fields, h.fields...)
+	r.Attrs(func(attr slog.Attr) bool {
+		fields = appendSlogAttr(fields, h.prefix, attr)
+		return true
+	})
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	//panic和fatal等级不在handler里触发退出 统一按error输出
+	switch FromSlogLevel(r.Level) {
+	case DebugLevel:
+		h.writer.DebugCtxW(ctx, r.Message, fields...)
+	case InfoLevel:
+		h.writer.InfoCtxW(ctx, r.Message, fields...)
+	case WarnLevel:
+		h.writer.WarnCtxW(ctx, r.Message, fields...)
+	default:
+		h.writer.ErrorCtxW(ctx, r.Message, fields...)
+	}
+	return nil
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) <= 0 {
+		return h
+	}
+	c := *h
+	c.fields = make([]LogField, 0, len(h.fields)+len(attrs))
+	c.fields = append(c.fields, h.fields...)
+	for _, attr := range attrs {
+		c.fields = appendSlogAttr(c.fields, h.prefix, attr)
+	}
+	return &c
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+func appendSlogAttr(fields []LogField, prefix string, attr slog.Attr) []LogField {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" { //key为空的group直接展开
+			groupPrefix = prefix + attr.Key + "."
+		}
+		for _, a := range attr.Value.Group() {
+			fields = appendSlogAttr(fields, groupPrefix, a)
+		}
+		return fields
+	}
+	return append(fields, Field(prefix+attr.Key, attr.Value.Any()))
+}
+
+// SlogWriter 以任意slog.Handler作为输出的Writer
+type SlogWriter struct {
+	handler     slog.Handler
+	level       *slog.LevelVar
+	name        string
+	stackOffset int
+	metrics     *WriterMetrics
+	redact      *redaction
+	sampler     *sampler
+	dedup       *deduper
+	context     string //With和Named绑定的字段 用于判断重复
+}
+
+func NewSlogWriter(h slog.Handler) Writer {
+	w := &SlogWriter{
+		handler: h,
+		level:   new(slog.LevelVar),
+		metrics: newWriterMetrics(),
+		redact:  newRedaction(),
+		dedup:   newDeduper(),
+	}
+	w.sampler = newSampler(func(level int) bool {
+		return ToSlogLevel(level) >= w.level.Level()
+	}, w.logSummary)
+	return w
+}
+
+// logSummary 打印采样汇总 不经过采样
+func (w *SlogWriter) logSummary(level int, msg string, fields ...LogField) {
+	w.log(context.Background(), ToSlogLevel(level), msg, fields...)
+}
+
+func (w *SlogWriter) log(ctx context.Context, level slog.Level, msg string, fields ...LogField) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if level < w.level.Level() || !w.handler.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	// 跳过runtime.Callers、log和Writer方法 默认按包级函数调用计算
+	runtime.Callers(2+CallerSkipOffset+w.stackOffset, pcs[:])
+	redactor := w.redact.get()
+	now, msg, fields := time.Now(), redactor.Message(msg), redactor.Fields(fields)
+	emit := func(extra ...LogField) {
+		r := slog.NewRecord(now, level, msg, pcs[0])
+		for _, field := range fields {
+			r.AddAttrs(slog.Any(field.Key, field.Value))
+		}
+		for _, field := range extra {
+			r.AddAttrs(slog.Any(field.Key, field.Value))
+		}
+		w.metrics.addLine(FromSlogLevel(level))
+		if err := w.handler.Handle(ctx, r); err != nil {
+			log.Println("xlog slog handle error", err.Error())
+		}
+	}
+	if !w.dedup.enabled() {
+		emit()
+		return
+	}
+	w.dedup.log(dedupKey(levelName(FromSlogLevel(level)), msg, fields)+w.context, emit)
+}
+
+func (w *SlogWriter) SetStackOffset(offset int) {
+	w.stackOffset = offset
+}
+
+func (w *SlogWriter) SetConfig(config *config.LogConfig) {
+	if config == nil {
+		return
+	}
+	if err := w.redact.apply(config.Redact); err != nil {
+		panic(err)
+	}
+	w.sampler.apply(config.Sampling)
+	w.dedup.apply(config.Dedup)
+	w.SetLevel(config.LogLevel)
+}
+
+func (w *SlogWriter) ApplyConfig(config *config.LogConfig) error {
+	if config == nil {
+		return nil
+	}
+	err := ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	if err = w.redact.apply(config.Redact); err != nil {
+		return err
+	}
+	w.sampler.apply(config.Sampling)
+	w.dedup.apply(config.Dedup)
+	w.SetLevel(config.LogLevel)
+	return nil
+}
+
+func (w *SlogWriter) SetLevel(level string) {
+	if lv, ok := LogLevel[strings.ToLower(level)]; ok {
+		w.level.Set(ToSlogLevel(lv))
+	}
+}
+
+func (w *SlogWriter) GetLevel() int {
+	return FromSlogLevel(w.level.Level())
+}
+
+// Metrics 返回打印统计
+func (w *SlogWriter) Metrics() *WriterMetrics {
+	return w.metrics
+}
+
+func (w *SlogWriter) Close() {
+	w.dedup.flush()
+}
+
+func (w *SlogWriter) With(fields ...LogField) Writer {
+	c := *w
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range w.redact.get().Fields(fields) {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+	c.handler = w.handler.WithAttrs(attrs)
+	c.context = w.context + dedupKey("", "", fields)
+	return &c
+}
+
+func (w *SlogWriter) Named(name string) Writer {
+	c := *w
+	c.name = joinLoggerName(w.name, name)
+	c.handler = w.handler.WithAttrs([]slog.Attr{slog.String(LoggerKey, c.name)})
+	c.context = w.context + dedupKey("", "", []LogField{Field(LoggerKey, c.name)})
+	return &c
+}
+
+func (w *SlogWriter) Debug(v ...interface{}) {
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.log(context.Background(), slog.LevelDebug, fmt.Sprint(v...))
+}
+
+func (w *SlogWriter) DebugF(format string, fields ...interface{}) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelDebug, fmt.Sprintf(format, fields...))
+}
+
+func (w *SlogWriter) DebugW(format string, fields ...LogField) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelDebug, format, fields...)
+}
+
+func (w *SlogWriter) Info(v ...interface{}) {
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.log(context.Background(), slog.LevelInfo, fmt.Sprint(v...))
+}
+
+func (w *SlogWriter) InfoF(format string, fields ...interface{}) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelInfo, fmt.Sprintf(format, fields...))
+}
+
+func (w *SlogWriter) InfoW(format string, fields ...LogField) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelInfo, format, fields...)
+}
+
+func (w *SlogWriter) Warn(v ...interface{}) {
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.log(context.Background(), slog.LevelWarn, fmt.Sprint(v...))
+}
+
+func (w *SlogWriter) WarnF(format string, fields ...interface{}) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelWarn, fmt.Sprintf(format, fields...))
+}
+
+func (w *SlogWriter) WarnW(format string, fields ...LogField) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelWarn, format, fields...)
+}
+
+func (w *SlogWriter) Error(v ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.log(context.Background(), slog.LevelError, fmt.Sprint(v...))
+}
+
+func (w *SlogWriter) ErrorF(format string, fields ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelError, fmt.Sprintf(format, fields...))
+}
+
+func (w *SlogWriter) ErrorW(format string, fields ...LogField) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.log(context.Background(), slog.LevelError, format, fields...)
+}
+
+func (w *SlogWriter) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	w.log(context.Background(), SlogLevelPanic, msg)
+	panicAfterFlush(msg)
+}
+
+func (w *SlogWriter) PanicF(format string, fields ...interface{}) {
+	msg := fmt.Sprintf(format, fields...)
+	w.log(context.Background(), SlogLevelPanic, msg)
+	panicAfterFlush(msg)
+}
+
+func (w *SlogWriter) PanicW(format string, fields ...LogField) {
+	w.log(context.Background(), SlogLevelPanic, format, fields...)
+	panicAfterFlush(format)
+}
+
+func (w *SlogWriter) Fatal(v ...interface{}) {
+	w.log(context.Background(), SlogLevelFatal, fmt.Sprint(v...))
+	exitAfterFlush()
+}
+
+func (w *SlogWriter) FatalF(format string, fields ...interface{}) {
+	w.log(context.Background(), SlogLevelFatal, fmt.Sprintf(format, fields...))
+	exitAfterFlush()
+}
+
+func (w *SlogWriter) FatalW(format string, fields ...LogField) {
+	w.log(context.Background(), SlogLevelFatal, format, fields...)
+	exitAfterFlush()
+}
+
+func (w *SlogWriter) DebugCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.log(ctx, slog.LevelDebug, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) DebugCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelDebug, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) DebugCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelDebug, format, withContextFields(ctx, fields...)...)
+}
+
+func (w *SlogWriter) InfoCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.log(ctx, slog.LevelInfo, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) InfoCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelInfo, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) InfoCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelInfo, format, withContextFields(ctx, fields...)...)
+}
+
+func (w *SlogWriter) WarnCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.log(ctx, slog.LevelWarn, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) WarnCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelWarn, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) WarnCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelWarn, format, withContextFields(ctx, fields...)...)
+}
+
+func (w *SlogWriter) ErrorCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.log(ctx, slog.LevelError, fmt.Sprint(v...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) ErrorCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelError, fmt.Sprintf(format, fields...), FieldsFromContext(ctx)...)
+}
+
+func (w *SlogWriter) ErrorCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.log(ctx, slog.LevelError, format, withContextFields(ctx, fields...)...)
+}
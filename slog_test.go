@@ -0,0 +1,109 @@
+//go:build go1.21
+
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/crx666/xlog/config"
+)
+
+func TestSlogHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.SetLevel(LevelInfo)
+	logger := slog.New(NewSlogHandler(w, nil)).With("service", "order").WithGroup("req")
+	logger.Debug("slog debug")
+	logger.Warn("slog warn", "id", 1, slog.Group("user", "name", "xxx"))
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry[LevelKey] != LevelWarn || entry["service"] != "order" || entry["req.id"] != float64(1) || entry["req.user.name"] != "xxx" {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+func TestSlogWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewSlogWriter(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	w.SetLevel(LevelWarn)
+	w.Named("svc").InfoW("slog infow", Field("i", 1))
+	w.Named("svc").ErrorW("slog errorw", Field("i", 2))
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "slog errorw" || entry[LoggerKey] != "svc" || entry["i"] != float64(2) {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+func TestSlogWriterRedact(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewSlogWriter(slog.NewJSONHandler(buf, nil))
+	err := w.ApplyConfig(&config.LogConfig{IsConsole: true, LogLevel: LevelInfo, Redact: &config.Redact{Keys: []string{"*_token"}, Patterns: []string{`\d{11}`}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.With(Field("api_token", "abc")).InfoW("phone 13800001234", Field("user", &redactUser{Card: "card"}))
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := entry["user"].(map[string]interface{})
+	if entry["msg"] != "phone "+DefaultRedactMask || entry["api_token"] != DefaultRedactMask || user["Card"] != DefaultRedactMask {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestSlogWriterHandleError(t *testing.T) {
+	// handler出错时打印到标准log 不输出到stdout
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	w := NewSlogWriter(slog.NewJSONHandler(failWriter{}, nil))
+	w.Error("slog error")
+	if !bytes.Contains(buf.Bytes(), []byte("xlog slog handle error disk full")) {
+		t.Fatalf("handle error not reported: %q", buf.String())
+	}
+}
+
+func TestSlogWriterDedup(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewSlogWriter(slog.NewJSONHandler(buf, nil))
+	if err := w.ApplyConfig(&config.LogConfig{IsConsole: true, LogLevel: LevelInfo, Dedup: &config.Dedup{Window: 200}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		w.WarnW("retry failed", Field("host", "db1"))
+	}
+	w.Close()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("lines %d: %s", len(lines), buf.String())
+	}
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(lines[1], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "retry failed" || entry["host"] != "db1" || entry[RepeatCountKey] != float64(2) {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+}
@@ -0,0 +1,275 @@
+package xlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crx666/xlog/config"
+)
+
+const (
+	SyslogRFC5424 = "rfc5424"
+	SyslogRFC3164 = "rfc3164"
+
+	DefaultSyslogAddress = "/dev/log"
+	DefaultSyslogSdId    = "fields@32473" //32473为文档示例用的企业编号
+)
+
+var (
+	ErrUnknownSyslogFormat   = errors.New("unknown syslog format")
+	ErrUnknownSyslogFacility = errors.New("unknown syslog facility")
+	ErrSyslogConflict        = errors.New("syslog can not be set with network rotatelog or lumberjack")
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverity 日志等级对应的syslog severity
+var syslogSeverity = [FatalLevel + 1]int{
+	DebugLevel: 7, //debug
+	InfoLevel:  6, //informational
+	WarnLevel:  4, //warning
+	ErrorLevel: 3, //err
+	PanicLevel: 2, //crit
+	FatalLevel: 1, //alert
+}
+
+// SyslogLogFile 把编码后的日志转成syslog帧发送 json格式的日志字段转成structured data
+// 传输复用NetworkLogFile 断线重连但不使用spool tcp使用octet counting分帧
+type SyslogLogFile struct {
+	conn     *NetworkLogFile
+	format   string
+	facility int
+	hostname string
+	appName  string
+	procId   string
+	sdId     string
+	octet    bool
+}
+
+var _ LogFileWrite = (*SyslogLogFile)(nil)
+
+// NewSyslogLogWriter 根据配置创建syslog输出
+func NewSyslogLogWriter(cfg *config.Syslog) (LogFileWrite, error) {
+	return NewSyslogLogFile(cfg)
+}
+
+func NewSyslogLogFile(cfg *config.Syslog) (*SyslogLogFile, error) {
+	if err := validateSyslog(cfg); err != nil {
+		return nil, err
+	}
+	s := &SyslogLogFile{
+		format:   strings.ToLower(cfg.Format),
+		facility: syslogFacilities["user"],
+		hostname: cfg.Hostname,
+		appName:  cfg.AppName,
+		procId:   strconv.Itoa(os.Getpid()),
+		sdId:     cfg.SdId,
+	}
+	if s.format == "" {
+		s.format = SyslogRFC5424
+	}
+	if cfg.Facility != "" {
+		s.facility = syslogFacilities[strings.ToLower(cfg.Facility)]
+	}
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	if s.appName == "" {
+		s.appName = filepath.Base(os.Args[0])
+	}
+	if s.sdId == "" {
+		s.sdId = DefaultSyslogSdId
+	}
+	network := syslogNetwork(cfg)
+	s.octet = network.Protocol == NetworkTCP
+	conn, err := NewNetworkLogFile(network, "")
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+// syslogNetwork 默认通过unix datagram发送到/dev/log
+func syslogNetwork(cfg *config.Syslog) *config.Network {
+	network := &config.Network{
+		Protocol: strings.ToLower(cfg.Protocol),
+		Address:  cfg.Address,
+		Tls:      cfg.Tls,
+	}
+	if network.Protocol == "" {
+		network.Protocol = NetworkUnixgram
+	}
+	if network.Address == "" {
+		network.Address = DefaultSyslogAddress
+	}
+	return network
+}
+
+func validateSyslog(cfg *config.Syslog) error {
+	switch strings.ToLower(cfg.Format) {
+	case "", SyslogRFC5424, SyslogRFC3164:
+	default:
+		return newConfigError("syslog.format", cfg.Format, ErrUnknownSyslogFormat)
+	}
+	if _, ok := syslogFacilities[strings.ToLower(cfg.Facility)]; !ok && cfg.Facility != "" {
+		return newConfigError("syslog.facility", cfg.Facility, ErrUnknownSyslogFacility)
+	}
+	if err := validateNetwork(syslogNetwork(cfg)); err != nil {
+		if e, ok := err.(*ConfigError); ok {
+			e.Field = strings.Replace(e.Field, "network.", "syslog.", 1)
+		}
+		return err
+	}
+	return nil
+}
+
+// Write p中每一行作为一条syslog消息 等级从每行中解析
+func (s *SyslogLogFile) Write(p []byte) (int, error) {
+	return s.WriteLevel(-1, p)
+}
+
+// WriteLevel 使用传入的等级计算PRI 非json格式的日志无法从行中解析等级
+func (s *SyslogLogFile) WriteLevel(level int, p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if _, err := s.conn.Write(s.frame(level, line, time.Now())); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (s *SyslogLogFile) Exit() error {
+	return s.conn.Exit()
+}
+
+// Frame 把一行日志转成syslog帧 tcp时带上长度前缀
+func (s *SyslogLogFile) Frame(line []byte, now time.Time) []byte {
+	return s.frame(-1, line, now)
+}
+
+// frame level超出范围时使用行中解析的等级
+func (s *SyslogLogFile) frame(level int, line []byte, now time.Time) []byte {
+	lineLevel, msg, fields := parseSyslogLine(line)
+	if level < DebugLevel || level > FatalLevel {
+		level = lineLevel
+	}
+	pri := s.facility*8 + syslogSeverity[level]
+	var buf bytes.Buffer
+	if s.format == SyslogRFC3164 {
+		fmt.Fprintf(&buf, "<%d>%s %s %s[%s]: %s", pri, now.Format(time.Stamp),
+			syslogHeader(s.hostname, 255), syslogHeader(s.appName, 32), s.procId, msg)
+		for _, field := range fields {
+			fmt.Fprintf(&buf, " %s=%s", field.Key, field.Value)
+		}
+	} else {
+		fmt.Fprintf(&buf, "<%d>1 %s %s %s %s - ", pri, now.Format("2006-01-02T15:04:05.000000Z07:00"),
+			syslogHeader(s.hostname, 255), syslogHeader(s.appName, 48), s.procId)
+		writeStructuredData(&buf, s.sdId, fields)
+		if msg != "" {
+			buf.WriteByte(' ')
+			buf.WriteString(msg)
+		}
+	}
+	if !s.octet {
+		return buf.Bytes()
+	}
+	return append([]byte(strconv.Itoa(buf.Len())+" "), buf.Bytes()...)
+}
+
+// syslogHeader 头部字段只能是可见ASCII 为空时用-
+func syslogHeader(v string, max int) string {
+	b := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(b) < max; i++ {
+		if c := v[i]; c > 32 && c < 127 {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+var sdValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// writeStructuredData 字段为空时写- 参数名去掉不允许的字符并截断到32个字符
+func writeStructuredData(buf *bytes.Buffer, sdId string, fields []syslogField) {
+	if len(fields) == 0 {
+		buf.WriteByte('-')
+		return
+	}
+	buf.WriteByte('[')
+	buf.WriteString(sdId)
+	for _, field := range fields {
+		name := strings.Map(func(r rune) rune {
+			if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+				return '_'
+			}
+			return r
+		}, field.Key)
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		fmt.Fprintf(buf, ` %s="%s"`, name, sdValueReplacer.Replace(field.Value))
+	}
+	buf.WriteByte(']')
+}
+
+type syslogField struct {
+	Key   string
+	Value string
+}
+
+// parseSyslogLine json格式取出等级 内容和其他字段 嵌套的对象展开成a.b 其他格式整行作为内容
+func parseSyslogLine(line []byte) (int, string, []syslogField) {
+	level, msg, entry, ok := parseLineEntry(line)
+	if !ok {
+		return level, string(line), nil
+	}
+	var fields []syslogField
+	flattenSyslogFields("", entry, &fields)
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Key < fields[j].Key
+	})
+	if msg == nil {
+		return level, "", fields
+	}
+	return level, syslogValue(msg), fields
+}
+
+func flattenSyslogFields(prefix string, entry map[string]interface{}, fields *[]syslogField) {
+	for k, v := range entry {
+		if m, ok := v.(map[string]interface{}); ok {
+			flattenSyslogFields(prefix+k+".", m, fields)
+			continue
+		}
+		*fields = append(*fields, syslogField{Key: prefix + k, Value: syslogValue(v)})
+	}
+}
+
+func syslogValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,180 @@
+package xlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+const TraceparentHeader = "traceparent"
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceContext W3C Trace Context中的trace id和span id 均为小写十六进制
+type TraceContext struct {
+	TraceId      string
+	SpanId       string
+	ParentSpanId string //来自上游traceparent的span id 本地新建时为空
+	Flags        byte   //trace-flags 最低位为sampled
+}
+
+type traceContextKey struct{}
+
+type traceKeys struct {
+	trace string
+	span  string
+}
+
+var traceFieldKeys atomic.Value //traceKeys
+
+func init() {
+	traceFieldKeys.Store(traceKeys{trace: TraceIdKey, span: SpanIdKey})
+}
+
+// SetTraceFieldKeys 修改Ctx系列打印时trace id和span id的字段名 为空时使用默认的trace_id和span_id
+func SetTraceFieldKeys(traceKey, spanKey string) {
+	if traceKey == "" {
+		traceKey = TraceIdKey
+	}
+	if spanKey == "" {
+		spanKey = SpanIdKey
+	}
+	traceFieldKeys.Store(traceKeys{trace: traceKey, span: spanKey})
+}
+
+// TraceFieldKeys 当前trace id和span id的字段名
+func TraceFieldKeys() (traceKey, spanKey string) {
+	keys := traceFieldKeys.Load().(traceKeys)
+	return keys.trace, keys.span
+}
+
+// NewTraceId 随机生成16字节的trace id
+func NewTraceId() string {
+	return randomHexId(16)
+}
+
+// NewSpanId 随机生成8字节的span id
+func NewSpanId() string {
+	return randomHexId(8)
+}
+
+func randomHexId(n int) string {
+	b := make([]byte, n)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		for _, v := range b { //全0的id无效
+			if v != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+// NewTraceContext 新建一个sampled的trace
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceId: NewTraceId(), SpanId: NewSpanId(), Flags: 1}
+}
+
+// ParseTraceparent 解析traceparent 如00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+// 版本00长度必须为55 更高的版本只解析前四段
+func ParseTraceparent(header string) (TraceContext, error) {
+	header = strings.TrimSpace(header)
+	if len(header) < 55 || (len(header) > 55 && header[55] != '-') {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+	parts := strings.SplitN(header[:55], "-", 4)
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[3]) != 2 {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(header) != 55) || !isLowerHex(header[:55]) {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+	if !isHexId(parts[1], 16) || !isHexId(parts[2], 8) {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return TraceContext{}, ErrInvalidTraceparent
+	}
+	return TraceContext{TraceId: parts[1], SpanId: parts[2], Flags: flags[0]}, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'A' && c <= 'F' {
+			return false
+		}
+	}
+	return true
+}
+
+// Traceparent 转成版本00的traceparent
+func (t TraceContext) Traceparent() string {
+	return "00-" + t.TraceId + "-" + t.SpanId + "-" + hex.EncodeToString([]byte{t.Flags})
+}
+
+// Sampled trace-flags中的sampled位
+func (t TraceContext) Sampled() bool {
+	return t.Flags&1 == 1
+}
+
+// IsValid trace id和span id都合法
+func (t TraceContext) IsValid() bool {
+	return isHexId(t.TraceId, 16) && isHexId(t.SpanId, 8)
+}
+
+// ChildSpan 同一个trace下新建span 原span作为父span
+func (t TraceContext) ChildSpan() TraceContext {
+	return TraceContext{TraceId: t.TraceId, SpanId: NewSpanId(), ParentSpanId: t.SpanId, Flags: t.Flags}
+}
+
+// ContextWithTrace 把trace绑定到context上 后续Ctx系列打印会自动带上trace id和span id
+func ContextWithTrace(ctx context.Context, t TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, t)
+}
+
+// TraceFromContext 返回context上绑定的trace
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+	t, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return t, ok
+}
+
+// ContextWithTraceparent 解析traceparent并在其下新建span绑定到context上 解析失败时新建trace
+func ContextWithTraceparent(ctx context.Context, header string) context.Context {
+	t, err := ParseTraceparent(header)
+	if err != nil {
+		return ContextWithTrace(ctx, NewTraceContext())
+	}
+	return ContextWithTrace(ctx, t.ChildSpan())
+}
+
+// TraceHandler 从请求头的traceparent中取出trace绑定到请求的context上 没有时新建trace
+// 响应头中带上本次请求的traceparent
+func TraceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ContextWithTraceparent(r.Context(), r.Header.Get(TraceparentHeader))
+		t, _ := TraceFromContext(ctx)
+		w.Header().Set(TraceparentHeader, t.Traceparent())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceContextFields 内置的context提取器 有trace时带上trace id和span id
+func traceContextFields(ctx context.Context) []LogField {
+	t, ok := TraceFromContext(ctx)
+	if !ok || !t.IsValid() {
+		return nil
+	}
+	traceKey, spanKey := TraceFieldKeys()
+	return []LogField{{Key: traceKey, Value: t.TraceId}, {Key: spanKey, Value: t.SpanId}}
+}
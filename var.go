@@ -23,6 +23,9 @@ const (
 	ContentKey   = "content"
 	LevelKey     = "level"
 	TimestampKey = "@timestamp"
+	LoggerKey    = "logger"
+	TraceIdKey   = "trace_id"
+	SpanIdKey    = "span_id"
 
 	LevelInfo  = "info"
 	LevelWarn  = "warn"
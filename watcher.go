@@ -0,0 +1,112 @@
+package xlog
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const DefaultWatchInterval = 5 * time.Second
+
+// ConfigWatcher 轮询配置文件 变化后把配置应用到正在使用的writer上
+type ConfigWatcher struct {
+	path      string
+	writer    Writer
+	interval  time.Duration
+	onError   func(err error)
+	modTime   time.Time
+	content   []byte
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+// WatchConfig 监听配置文件 interval<=0时使用DefaultWatchInterval onError为空时打印到标准日志
+func WatchConfig(path string, w Writer, interval time.Duration, onError func(err error)) (*ConfigWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	if onError == nil {
+		onError = func(err error) {
+			log.Println("xlog watch config error", err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &ConfigWatcher{
+		path:      path,
+		writer:    w,
+		interval:  interval,
+		onError:   onError,
+		modTime:   info.ModTime(),
+		content:   content,
+		closeChan: make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *ConfigWatcher) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case <-ticker.C:
+			if err := c.check(); err != nil {
+				c.onError(err)
+			}
+		}
+	}
+}
+
+// check 文件修改时间或内容变化时重新加载 出错时保留旧配置
+func (c *ConfigWatcher) check() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(c.modTime) {
+		return nil
+	}
+	content, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	c.modTime = info.ModTime()
+	if bytes.Equal(content, c.content) {
+		return nil
+	}
+	c.content = content
+	return c.reload()
+}
+
+func (c *ConfigWatcher) reload() (err error) {
+	defer func() {
+		if r := recover(); r != nil { //创建日志文件失败等情况不让进程崩溃
+			err = fmt.Errorf("reload config %s panic: %v", c.path, r)
+		}
+	}()
+	cfg, err := LoadLogConfig(c.path)
+	if err != nil {
+		return err
+	}
+	return c.writer.ApplyConfig(cfg)
+}
+
+// Stop 停止监听
+func (c *ConfigWatcher) Stop() {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+}
@@ -1,11 +1,14 @@
 package xlog
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/crx666/xlog/config"
 
@@ -29,10 +32,38 @@ type Writer interface {
 	ErrorF(format string, fields ...interface{})
 	ErrorW(format string, fields ...LogField)
 
+	Panic(v ...interface{})
+	PanicF(format string, fields ...interface{})
+	PanicW(format string, fields ...LogField)
+
+	Fatal(v ...interface{})
+	FatalF(format string, fields ...interface{})
+	FatalW(format string, fields ...LogField)
+
+	DebugCtx(ctx context.Context, v ...interface{})
+	DebugCtxF(ctx context.Context, format string, fields ...interface{})
+	DebugCtxW(ctx context.Context, format string, fields ...LogField)
+
+	InfoCtx(ctx context.Context, v ...interface{})
+	InfoCtxF(ctx context.Context, format string, fields ...interface{})
+	InfoCtxW(ctx context.Context, format string, fields ...LogField)
+
+	WarnCtx(ctx context.Context, v ...interface{})
+	WarnCtxF(ctx context.Context, format string, fields ...interface{})
+	WarnCtxW(ctx context.Context, format string, fields ...LogField)
+
+	ErrorCtx(ctx context.Context, v ...interface{})
+	ErrorCtxF(ctx context.Context, format string, fields ...interface{})
+	ErrorCtxW(ctx context.Context, format string, fields ...LogField)
+
+	With(fields ...LogField) Writer
+	Named(name string) Writer
+
 	SetLevel(level string)
 	GetLevel() int
 
 	SetConfig(cfg *config.LogConfig)
+	ApplyConfig(cfg *config.LogConfig) error
 	SetStackOffset(offset int)
 	Close()
 }
@@ -48,6 +79,13 @@ func (l *LogWriter) SetWriter(writer Writer) {
 	l.writer = writer
 }
 
+// ReplaceWriter 强制替换 返回旧的writer
+func (l *LogWriter) ReplaceWriter(writer Writer) Writer {
+	old := l.writer
+	l.writer = writer
+	return old
+}
+
 func (l *LogWriter) GetWriter() Writer {
 	return l.writer
 }
@@ -59,6 +97,20 @@ type LogFileWrite interface {
 
 type NormalLogFile struct {
 	File *os.File
+	mu   sync.Mutex
+	name string //Flush把.temp改成.log后的文件名
+}
+
+// makeLogDir 提前创建日志目录 尽早发现目录不可写 目录带$rand时每次生成的目录不同 不提前创建
+func makeLogDir(dir string) error {
+	if strings.Contains(dir, "$rand") {
+		return nil
+	}
+	newDir := common.ReplaceDir(dir)
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return newConfigError("log_dir", newDir, fmt.Errorf("%w: %s", ErrOpenLogFile, err.Error()))
+	}
+	return nil
 }
 
 func NewNormalLogFile(dir, name string) (*NormalLogFile, error) {
@@ -102,6 +154,34 @@ func (l *NormalLogFile) Write(p []byte) (n int, err error) {
 	return l.File.Write(p)
 }
 
+func (l *NormalLogFile) fileName() string {
+	if l.name != "" {
+		return l.name
+	}
+	return l.File.Name()
+}
+
+// Flush 同步到磁盘并把.temp改成.log 文件保持打开
+func (l *NormalLogFile) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.File.Sync()
+	if name, err := common.RenameLogFile(l.fileName()); err == nil {
+		l.name = name
+	}
+}
+
 func (l *NormalLogFile) Exit() error {
-	return l.File.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	name := l.fileName()
+	err := l.File.Close()
+	if err != nil {
+		return err
+	}
+	return common.ReplaceLogName(name)
+}
+
+func (l *NormalLogFile) Sync() error {
+	return l.File.Sync()
 }
@@ -1,10 +1,11 @@
 package xlog
 
 import (
+	"context"
 	"fmt"
 	"go.uber.org/zap"
-	"io"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/crx666/xlog/config"
@@ -20,9 +21,6 @@ const (
 	CallerSkipOffset  = 2
 )
 
-var normalLevel = zap.NewAtomicLevel()
-var errLevel = zap.NewAtomicLevelAt(zap.ErrorLevel)
-
 func customTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendString(t.Format(fmt.Sprintf("[%s]", TimeFormat)))
 }
@@ -48,31 +46,54 @@ type ZapWriter struct {
 	stackOffset int //默认输出为0
 	encodeType  int
 	opts        []zap.Option
-	logger      *zap.Logger
+	logger      *atomic.Value //*zap.Logger 热加载时整体替换 With和Named派生的子logger共享
+	name        string        //Named绑定的名称
+	fields      []zap.Field   //With绑定的字段
+	derived     *atomic.Value //zapDerived 缓存加上名称和字段后的logger
+	sinks       *logSinks
+	normalLevel zap.AtomicLevel //每个writer独立的打印等级
+	errLevel    zap.AtomicLevel //错误日志文件的打印等级
+	metrics     *WriterMetrics
+	redact      *redaction
+	sampler     *sampler
+	dedup       *deduper
 }
 
 func NewZapWriter(encodeType int, opts ...zap.Option) (Writer, error) {
-	var logger *zap.Logger
-	var err error
+	var cfg zap.Config
+	metrics, redact := newWriterMetrics(), newRedaction()
+	opts = append(opts, zap.WithFatalHook(fatalHook{}), zap.Hooks(metrics.zapHook)) //fatal退出前刷新所有日志
 
 	if encodeType == JsonEncodingType {
-		logger, err = zap.NewProduction(opts...)
-		if err != nil {
-			return nil, err
-		}
+		cfg = zap.NewProductionConfig()
 	} else {
-		logger, err = zap.NewDevelopment(opts...)
-		if err != nil {
-			return nil, err
-		}
+		cfg = zap.NewDevelopmentConfig()
+	}
+	normalLevel := zap.NewAtomicLevelAt(cfg.Level.Level())
+	cfg.Level = normalLevel
+	logger, err := cfg.Build(append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newRedactCore(core, redact)
+	}))...)
+	if err != nil {
+		return nil, err
 	}
 
 	w := &ZapWriter{
-		logger:      logger,
 		encodeType:  encodeType,
 		opts:        opts,
 		stackOffset: DefaultSkipOffset,
+		normalLevel: normalLevel,
+		errLevel:    zap.NewAtomicLevelAt(zap.ErrorLevel),
+		metrics:     metrics,
+		redact:      redact,
+		logger:      new(atomic.Value),
+		derived:     new(atomic.Value),
+		dedup:       newDeduper(),
 	}
+	w.sampler = newSampler(func(level int) bool {
+		return w.normalLevel.Enabled(toZapLevel(level))
+	}, w.logSummary)
+	w.logger.Store(logger)
 	return w, nil
 }
 
@@ -85,11 +106,24 @@ func (w *ZapWriter) SetLevel(level string) {
 	if err != nil {
 		lv = zapcore.DebugLevel
 	}
-	normalLevel.SetLevel(lv)
+	w.normalLevel.SetLevel(lv)
 }
 
 func (w *ZapWriter) GetLevel() int {
-	return LogLevel[normalLevel.String()]
+	return LogLevel[w.normalLevel.String()]
+}
+
+// SetErrLevel 设置错误日志文件的打印等级 默认error
+func (w *ZapWriter) SetErrLevel(level string) {
+	lv, err := zapcore.ParseLevel(level)
+	if err != nil {
+		lv = zapcore.ErrorLevel
+	}
+	w.errLevel.SetLevel(lv)
+}
+
+func (w *ZapWriter) GetErrLevel() int {
+	return LogLevel[w.errLevel.String()]
 }
 
 func (w *ZapWriter) SetConfig(config *config.LogConfig) {
@@ -100,136 +134,350 @@ func (w *ZapWriter) SetConfig(config *config.LogConfig) {
 	if err != nil {
 		panic(err)
 	}
+	err = w.applyConfig(config)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ApplyConfig 同SetConfig 先完整检查配置 出错时返回错误不panic
+func (w *ZapWriter) ApplyConfig(config *config.LogConfig) error {
+	if config == nil {
+		return nil
+	}
+	err := ValidateConfig(config)
+	if err != nil {
+		return err
+	}
+	return w.applyConfig(config)
+}
+
+func (w *ZapWriter) applyConfig(config *config.LogConfig) error {
 	level, err := zapcore.ParseLevel(config.LogLevel)
 	if err != nil {
 		level = zapcore.DebugLevel
 	}
-	normalLevel.SetLevel(level)
+	w.normalLevel.SetLevel(level)
+	if err = w.redact.apply(config.Redact); err != nil {
+		return err
+	}
+	w.sampler.apply(config.Sampling)
+	w.dedup.apply(config.Dedup)
+	if w.sinks == nil {
+		w.sinks = newLogSinks(w.metrics)
+	}
+	err = w.sinks.apply(config)
+	if err != nil {
+		return err
+	}
+
+	opts := append([]zap.Option(nil), w.opts...)
+	if !config.IsProd {
+		opts = append(opts, zap.AddStacktrace(zap.WarnLevel))
+	}
+	if config.IsCall {
+		opts = append(opts, zap.AddCaller())
+		if w.stackOffset != 0 {
+			opts = append(opts, zap.AddCallerSkip(CallerSkipOffset+w.stackOffset))
+		} else {
+			opts = append(opts, zap.AddCallerSkip(CallerSkipOffset))
+		}
+	}
+	w.logger.Store(zap.New(w.newCore(), opts...))
+	return nil
+}
+
+// newCore 控制台和文件输出都走可替换的sinks 热加载时不需要重建core
+func (w *ZapWriter) newCore() zapcore.Core {
 	var encoder zapcore.Encoder
 	if w.encodeType == JsonEncodingType {
 		encoder = getJsonEncoder()
 	} else {
 		encoder = getEncoder()
 	}
-
-	cores := []zapcore.Core{}
-	if config.IsConsole {
-		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(os.Stderr), level))
-	}
-	if config.LogDir != "" && config.LogName != "" {
-		var info, warn io.Writer
-		if config.Rotatelog != nil {
-			info = GetRotateLogWriter(config.LogDir, config.LogName, config.Rotatelog)
-			if config.ErrLogName != "" {
-				warn = GetRotateLogWriter(config.LogDir, config.ErrLogName, config.Rotatelog)
-			}
-		} else if config.Lumberjack != nil {
-			info = GetLumberjackLogWriter(config.LogDir, config.LogName, config.Lumberjack)
-			if config.ErrLogName != "" {
-				warn = GetLumberjackLogWriter(config.LogDir, config.ErrLogName, config.Lumberjack)
-			}
-		} else { //没有就默认创建一个日志文件
-			info, err = NewNormalLogFile(config.LogDir, config.LogName)
-			if err != nil {
-				panic(err)
-			}
-			if config.ErrLogName != "" {
-				warn, err = NewNormalLogFile(config.LogDir, config.ErrLogName)
-				if err != nil {
-					panic(err)
-				}
-			}
+	sinks, normalLevel, errLevel := w.sinks, w.normalLevel, w.errLevel
+	consoleLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return sinks.isConsole() && normalLevel.Enabled(lvl)
+	})
+	infoLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		if !normalLevel.Enabled(lvl) || !sinks.info.enabled() {
+			return false
 		}
-
-		var infoLevel zap.LevelEnablerFunc
-		if config.ErrLogName != "" {
-			infoLevel = func(lvl zapcore.Level) bool {
-				return normalLevel.Level() <= lvl && lvl <= zapcore.WarnLevel
-			}
-		} else {
-			infoLevel = func(lvl zapcore.Level) bool {
-				return normalLevel.Level() <= lvl && lvl <= zapcore.FatalLevel
-			}
+		if sinks.isSplitErr() { //错误日志单独文件时 正常日志只打印到warn
+			return lvl <= zapcore.WarnLevel
 		}
+		return lvl <= zapcore.FatalLevel
+	})
+	warnLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return sinks.isSplitErr() && errLevel.Enabled(lvl)
+	})
+	return newDedupCore(zapcore.NewTee(
+		newRedactCore(zapcore.NewCore(encoder, zapcore.AddSync(os.Stderr), consoleLevel), w.redact),
+		newRedactCore(newLevelCore(encoder, sinks.info, infoLevel), w.redact), //输出到日志文件
+		newRedactCore(newLevelCore(encoder, sinks.err, warnLevel), w.redact),  //错误输出到日志文件
+	), w.dedup)
+}
 
-		if info != nil {
-			cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(info), infoLevel)) //输出到日志文件
-		}
-		if warn != nil {
-			//warnLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-			//	return lvl >= zapcore.ErrorLevel
-			//})
-			cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(warn), errLevel)) //错误输出到日志文件
-		}
+// zapDerived 子logger在root上加上名称和字段 root被热加载替换后重新生成
+type zapDerived struct {
+	root   *zap.Logger
+	logger *zap.Logger
+}
+
+func (w *ZapWriter) getLogger() *zap.Logger {
+	root := w.logger.Load().(*zap.Logger)
+	if w.name == "" && len(w.fields) <= 0 {
+		return root
 	}
-	core := zapcore.NewTee(
-		cores...,
-	)
-	if !config.IsProd {
-		w.opts = append(w.opts, zap.AddStacktrace(zap.WarnLevel))
+	if d, ok := w.derived.Load().(zapDerived); ok && d.root == root {
+		return d.logger
 	}
-	if config.IsCall {
-		w.opts = append(w.opts, zap.AddCaller())
-		if w.stackOffset != 0 {
-			w.opts = append(w.opts, zap.AddCallerSkip(CallerSkipOffset+w.stackOffset))
-		} else {
-			w.opts = append(w.opts, zap.AddCallerSkip(CallerSkipOffset))
-		}
+	logger := root
+	if w.name != "" {
+		logger = logger.Named(w.name)
+	}
+	if len(w.fields) > 0 {
+		logger = logger.With(w.fields...)
 	}
-	logger := zap.New(core, w.opts...)
-	w.logger = logger
+	w.derived.Store(zapDerived{root: root, logger: logger})
+	return logger
+}
+
+func (w *ZapWriter) clone() *ZapWriter {
+	c := *w
+	c.opts = append([]zap.Option(nil), w.opts...)
+	c.fields = append([]zap.Field(nil), w.fields...)
+	c.derived = new(atomic.Value)
+	return &c
+}
+
+func (w *ZapWriter) With(fields ...LogField) Writer {
+	c := w.clone()
+	c.fields = append(c.fields, toZapFields(fields...)...)
+	return c
+}
+
+func (w *ZapWriter) Named(name string) Writer {
+	c := w.clone()
+	c.name = joinLoggerName(w.name, name)
+	return c
+}
+
+// Metrics 返回打印统计
+func (w *ZapWriter) Metrics() *WriterMetrics {
+	return w.metrics
 }
 
 func (w *ZapWriter) Close() {
-	w.logger.Sync()
+	w.dedup.flush()
+	w.getLogger().Sync()
+	if w.sinks != nil {
+		w.sinks.exit()
+	}
+}
+
+func (w *ZapWriter) flush() {
+	w.dedup.flush()
+	if w.sinks != nil {
+		w.sinks.flush()
+	}
 }
 
 func (w *ZapWriter) Error(v ...interface{}) {
-	w.logger.Error(fmt.Sprint(v...))
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.getLogger().Error(fmt.Sprint(v...))
 }
 
 func (w *ZapWriter) ErrorF(format string, fields ...interface{}) {
-	w.logger.Error(fmt.Sprintf(format, fields...))
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.getLogger().Error(fmt.Sprintf(format, fields...))
 }
 
 func (w *ZapWriter) ErrorW(format string, fields ...LogField) {
-	w.logger.Error(format, toZapFields(fields...)...)
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.getLogger().Error(format, toZapFields(fields...)...)
 }
 
 func (w *ZapWriter) Debug(v ...interface{}) {
-	w.logger.Debug(fmt.Sprint(v...))
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.getLogger().Debug(fmt.Sprint(v...))
 }
 
 func (w *ZapWriter) DebugF(format string, fields ...interface{}) {
-	w.logger.Debug(fmt.Sprintf(format, fields...))
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.getLogger().Debug(fmt.Sprintf(format, fields...))
 }
 
 func (w *ZapWriter) DebugW(format string, fields ...LogField) {
-	w.logger.Debug(format, toZapFields(fields...)...)
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.getLogger().Debug(format, toZapFields(fields...)...)
 }
 
 func (w *ZapWriter) Info(v ...interface{}) {
-	w.logger.Info(fmt.Sprint(v...))
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.getLogger().Info(fmt.Sprint(v...))
 }
 
 func (w *ZapWriter) InfoF(format string, fields ...interface{}) {
-	w.logger.Info(fmt.Sprintf(format, fields...))
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.getLogger().Info(fmt.Sprintf(format, fields...))
 }
 
 func (w *ZapWriter) InfoW(format string, fields ...LogField) {
-	w.logger.Info(format, toZapFields(fields...)...)
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.getLogger().Info(format, toZapFields(fields...)...)
 }
 
 func (w *ZapWriter) Warn(v ...interface{}) {
-	w.logger.Warn(fmt.Sprint(v...))
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.getLogger().Warn(fmt.Sprint(v...))
 }
 
 func (w *ZapWriter) WarnF(format string, fields ...interface{}) {
-	w.logger.Warn(fmt.Sprintf(format, fields...))
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.getLogger().Warn(fmt.Sprintf(format, fields...))
 }
 
 func (w *ZapWriter) WarnW(format string, fields ...LogField) {
-	w.logger.Warn(format, toZapFields(fields...)...)
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.getLogger().Warn(format, toZapFields(fields...)...)
+}
+
+func (w *ZapWriter) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	defer panicAfterFlush(msg)
+	w.getLogger().Panic(msg)
+}
+
+func (w *ZapWriter) PanicF(format string, fields ...interface{}) {
+	msg := fmt.Sprintf(format, fields...)
+	defer panicAfterFlush(msg)
+	w.getLogger().Panic(msg)
+}
+
+func (w *ZapWriter) PanicW(format string, fields ...LogField) {
+	defer panicAfterFlush(format)
+	w.getLogger().Panic(format, toZapFields(fields...)...)
+}
+
+func (w *ZapWriter) Fatal(v ...interface{}) {
+	w.getLogger().Fatal(fmt.Sprint(v...))
+}
+
+func (w *ZapWriter) FatalF(format string, fields ...interface{}) {
+	w.getLogger().Fatal(fmt.Sprintf(format, fields...))
+}
+
+func (w *ZapWriter) FatalW(format string, fields ...LogField) {
+	w.getLogger().Fatal(format, toZapFields(fields...)...)
+}
+
+func (w *ZapWriter) ErrorCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, "", v) {
+		return
+	}
+	w.getLogger().Error(fmt.Sprint(v...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) ErrorCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.getLogger().Error(fmt.Sprintf(format, fields...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) ErrorCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(ErrorLevel, format, nil) {
+		return
+	}
+	w.getLogger().Error(format, toZapFields(withContextFields(ctx, fields...)...)...)
+}
+
+func (w *ZapWriter) DebugCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(DebugLevel, "", v) {
+		return
+	}
+	w.getLogger().Debug(fmt.Sprint(v...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) DebugCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.getLogger().Debug(fmt.Sprintf(format, fields...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) DebugCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(DebugLevel, format, nil) {
+		return
+	}
+	w.getLogger().Debug(format, toZapFields(withContextFields(ctx, fields...)...)...)
+}
+
+func (w *ZapWriter) InfoCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(InfoLevel, "", v) {
+		return
+	}
+	w.getLogger().Info(fmt.Sprint(v...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) InfoCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.getLogger().Info(fmt.Sprintf(format, fields...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) InfoCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(InfoLevel, format, nil) {
+		return
+	}
+	w.getLogger().Info(format, toZapFields(withContextFields(ctx, fields...)...)...)
+}
+
+func (w *ZapWriter) WarnCtx(ctx context.Context, v ...interface{}) {
+	if !w.sampler.allow(WarnLevel, "", v) {
+		return
+	}
+	w.getLogger().Warn(fmt.Sprint(v...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) WarnCtxF(ctx context.Context, format string, fields ...interface{}) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.getLogger().Warn(fmt.Sprintf(format, fields...), toZapFields(FieldsFromContext(ctx)...)...)
+}
+
+func (w *ZapWriter) WarnCtxW(ctx context.Context, format string, fields ...LogField) {
+	if !w.sampler.allow(WarnLevel, format, nil) {
+		return
+	}
+	w.getLogger().Warn(format, toZapFields(withContextFields(ctx, fields...)...)...)
 }
 
 func toZapFields(fields ...LogField) []zap.Field {
@@ -242,3 +490,195 @@ func toZapFields(fields ...LogField) []zap.Field {
 	}
 	return zapFields
 }
+
+// redactCore 编码前对内容和字段脱敏 With绑定的字段在绑定时处理
+type redactCore struct {
+	zapcore.Core
+	redact *redaction
+}
+
+func newRedactCore(core zapcore.Core, redact *redaction) zapcore.Core {
+	return &redactCore{Core: core, redact: redact}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(redactZapFields(c.redact.get(), fields)), redact: c.redact}
+}
+
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	redactor := c.redact.get()
+	ent.Message = redactor.Message(ent.Message)
+	return c.Core.Write(ent, redactZapFields(redactor, fields))
+}
+
+// dedupCore 合并连续重复的日志 With绑定的字段和logger名称也参与判断
+// 没有开启去重时直接使用内部core的Check
+type dedupCore struct {
+	zapcore.Core
+	dedup   *deduper
+	context string //With绑定的字段
+}
+
+func newDedupCore(core zapcore.Core, dedup *deduper) zapcore.Core {
+	return &dedupCore{Core: core, dedup: dedup}
+}
+
+func (c *dedupCore) With(fields []zapcore.Field) zapcore.Core {
+	return &dedupCore{Core: c.Core.With(fields), dedup: c.dedup, context: c.context + zapDedupFields(fields)}
+}
+
+func (c *dedupCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.dedup.enabled() {
+		return c.Core.Check(ent, ce)
+	}
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write 内部的tee不判断等级 打印时重新Check 只写入启用了该等级的core
+func (c *dedupCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	fields = append([]zapcore.Field(nil), fields...) //重复的汇总在之后打印 需要复制
+	key := dedupKey(ent.Level.String(), ent.Message, []LogField{{Key: LoggerKey, Value: ent.LoggerName}}) +
+		c.context + zapDedupFields(fields)
+	c.dedup.log(key, func(extra ...LogField) {
+		if ce := c.Core.Check(ent, nil); ce != nil {
+			ce.Write(append(fields[:len(fields):len(fields)], toZapFields(extra...)...)...)
+		}
+	})
+	return nil
+}
+
+func zapDedupFields(fields []zapcore.Field) string {
+	if len(fields) <= 0 {
+		return ""
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	return fmt.Sprint(enc.Fields)
+}
+
+// levelCore 同zapcore.NewCore 写文件时带上日志等级
+type levelCore struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	out *swapLogFile
+}
+
+func newLevelCore(enc zapcore.Encoder, out *swapLogFile, enab zapcore.LevelEnabler) zapcore.Core {
+	return &levelCore{LevelEnabler: enab, enc: enc, out: out}
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &levelCore{LevelEnabler: c.LevelEnabler, enc: c.enc.Clone(), out: c.out}
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *levelCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	_, err = c.out.WriteLevel(fromZapLevel(ent.Level), buf.Bytes())
+	buf.Free()
+	if err != nil {
+		return err
+	}
+	if ent.Level > zapcore.ErrorLevel { //同zapcore panic和fatal前同步文件
+		c.Sync()
+	}
+	return nil
+}
+
+func (c *levelCore) Sync() error {
+	return c.out.Sync()
+}
+
+func redactZapFields(redactor *Redactor, fields []zapcore.Field) []zapcore.Field {
+	var values []zapcore.Field
+	for i, f := range fields {
+		var value interface{}
+		switch {
+		case redactor.MatchKey(f.Key):
+			value = redactor.mask
+		case f.Interface != nil && f.Type == zapcore.ReflectType:
+			v, ok := redactor.redactValue(f.Interface)
+			if !ok {
+				continue
+			}
+			value = v
+		default:
+			continue
+		}
+		if values == nil {
+			values = make([]zapcore.Field, len(fields))
+			copy(values, fields)
+		}
+		values[i] = zap.Any(f.Key, value)
+	}
+	if values == nil {
+		return fields
+	}
+	return values
+}
+
+// logSummary 打印采样汇总 不经过采样
+func (w *ZapWriter) logSummary(level int, msg string, fields ...LogField) {
+	if ce := w.getLogger().Check(toZapLevel(level), msg); ce != nil {
+		ce.Write(toZapFields(fields...)...)
+	}
+}
+
+func fromZapLevel(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return DebugLevel
+	case zapcore.InfoLevel:
+		return InfoLevel
+	case zapcore.WarnLevel:
+		return WarnLevel
+	case zapcore.ErrorLevel:
+		return ErrorLevel
+	case zapcore.FatalLevel:
+		return FatalLevel
+	default:
+		return PanicLevel
+	}
+}
+
+func toZapLevel(level int) zapcore.Level {
+	switch level {
+	case DebugLevel:
+		return zapcore.DebugLevel
+	case InfoLevel:
+		return zapcore.InfoLevel
+	case WarnLevel:
+		return zapcore.WarnLevel
+	case ErrorLevel:
+		return zapcore.ErrorLevel
+	case PanicLevel:
+		return zapcore.PanicLevel
+	default:
+		return zapcore.FatalLevel
+	}
+}